@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/armory/spinnaker-tools/internal/pkg/accounts"
+	"github.com/armory/spinnaker-tools/internal/pkg/debug"
+	"github.com/armory/spinnaker-tools/internal/pkg/diagnostics"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var accountsFile string
+
+// planAccounts shows what apply would do for each account in an accounts file
+var planAccounts = &cobra.Command{
+	Use:   "plan",
+	Short: "Show what apply would create or update for each account in an accounts file",
+	Long: `Given an accounts file (a YAML list of clusters, and the service account and kubeconfig to create
+for each), will check each cluster and show, without changing anything, the namespaces, ServiceAccount
+and RBAC objects apply would create or update`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runAccounts(func(ctx diagnostics.Handler, f accounts.File) []accounts.Result {
+			return accounts.Plan(ctx, f, verbose)
+		})
+	},
+}
+
+// applyAccounts creates the service account and kubeconfig of each account in an accounts file
+var applyAccounts = &cobra.Command{
+	Use:   "apply",
+	Short: "Create the service account and kubeconfig of each account in an accounts file",
+	Long: `Given an accounts file (a YAML list of clusters, and the service account and kubeconfig to create
+for each), will do what create-service-account does for each, and (optionally) write every Spinnaker
+Kubernetes account to a single file. An account that fails doesn't stop the others.
+Changes to objects that already exist, such as deleting bindings no longer called for, are shown and
+confirmed for each account first, unless --yes is given.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runAccounts(func(ctx diagnostics.Handler, f accounts.File) []accounts.Result {
+			return accounts.Apply(ctx, f, commandLine(), assumeYes, verbose)
+		})
+	},
+}
+
+// Loads the accounts file, runs plan or apply, and reports on each account
+func runAccounts(run func(ctx diagnostics.Handler, f accounts.File) []accounts.Result) {
+	ctx, err := debug.NewContext(true)
+	if err != nil {
+		fmt.Println("TODO: This needs error handling")
+	}
+
+	f, err := accounts.Load(accountsFile)
+	if err != nil {
+		color.Red(err.Error())
+		os.Exit(1)
+	}
+
+	failed := false
+	for _, r := range run(ctx, f) {
+		if r.Entry.Context != "" {
+			color.Cyan("\n%s: %s/%s", r.Entry.Context, r.Entry.Namespace, r.Entry.ServiceAccountName)
+		}
+		if r.Err != nil {
+			failed = true
+			color.Red(r.Message)
+			color.Red(r.Err.Error())
+			continue
+		}
+		for _, line := range r.Changes {
+			fmt.Println("  " + line)
+		}
+		if r.Account.Name != "" {
+			color.Green("Created kubeconfig file at %s for Spinnaker account %s", r.Account.KubeconfigFile, r.Account.Name)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(planAccounts)
+	rootCmd.AddCommand(applyAccounts)
+
+	for _, c := range []*cobra.Command{planAccounts, applyAccounts} {
+		c.PersistentFlags().StringVarP(&accountsFile, "file", "f", "", "accounts file")
+		c.MarkPersistentFlagRequired("file")
+		c.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
+	}
+	applyAccounts.PersistentFlags().BoolVarP(&assumeYes, "yes", "y", false, "don't ask for confirmation before changing objects that already exist")
+}
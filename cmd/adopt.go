@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/armory/spinnaker-tools/internal/pkg/debug"
+	"github.com/armory/spinnaker-tools/internal/pkg/k8s"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var adoptDryRun bool
+
+// adopt takes over a service account that wasn't created by create-service-account
+var adopt = &cobra.Command{
+	Use:   "adopt",
+	Short: "Take over an existing service account, so the other commands manage it too",
+	Long: `Given a Kubernetes service account created some other way, will do the following, after showing
+what will change and asking for confirmation:
+	* find every RoleBinding and ClusterRoleBinding that references it
+	* label it and the bindings as create-service-account labels what it creates, and annotate them with
+	  who adopted them, when, and how
+	* record on it the access the bindings grant (its role preset, if they match one)
+Afterwards it is shown by list, create-kubeconfig records the credentials issued for it, and
+delete-service-account removes it and the adopted bindings. Bindings that also reference other
+subjects are left alone, as are the Roles and ClusterRoles bound.`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Create a debug context
+		ctx, err := debug.NewContext(true)
+		if err != nil {
+			fmt.Println("TODO: This needs error handling")
+		}
+
+		cluster := k8s.Cluster{
+			KubeconfigFile: sourceKubeconfig,
+			Context:        k8s.ClusterContext{ContextName: context},
+		}
+		serr, err := cluster.DefineCluster(ctx, verbose)
+		if err != nil || serr != "" {
+			color.Red("Defining cluster failed, exiting")
+			color.Red(serr)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+
+		sa := k8s.ServiceAccount{
+			Namespace:          namespace,
+			ServiceAccountName: serviceAccountName,
+		}
+		serr, err = cluster.SelectServiceAccount(ctx, &sa, verbose)
+		if err != nil || serr != "" {
+			color.Red("Selecting service account failed, exiting")
+			color.Red(serr)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+
+		color.Blue("Finding bindings for service account %s ...", sa.ServiceAccountName)
+		bindings, serr, err := cluster.FindBindings(sa, false, verbose)
+		if err != nil {
+			color.Red(serr)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+
+		color.Blue("In context %s:", cluster.Context.ContextName)
+		for _, line := range k8s.AdoptionSummary(sa, bindings) {
+			fmt.Println("  * " + line)
+		}
+		if adoptDryRun {
+			color.Yellow("Dry run: nothing was changed")
+			return
+		}
+
+		serr, err = k8s.ConfirmChanges(assumeYes)
+		if err != nil {
+			color.Red(serr)
+			os.Exit(1)
+		}
+
+		sa.Provenance = cluster.NewProvenance(commandLine(), verbose)
+		serr, err = cluster.AdoptServiceAccount(sa, bindings, verbose)
+		if err != nil {
+			color.Red("Adopting service account failed, exiting")
+			color.Red(serr)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		color.Green("Adopted service account %s", sa.ServiceAccountName)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(adopt)
+
+	adopt.PersistentFlags().StringVarP(&sourceKubeconfig, "kubeconfig", "i", "", "kubeconfig to start with")
+	adopt.PersistentFlags().StringVarP(&context, "context", "c", "", "kubectl context to use")
+	adopt.PersistentFlags().StringVarP(&namespace, "namespace", "n", "", "namespace of the service account (prompted for if not given)")
+	adopt.PersistentFlags().StringVarP(&serviceAccountName, "service-account-name", "s", "", "service account name (prompted for if not given)")
+	adopt.PersistentFlags().BoolVar(&adoptDryRun, "dry-run", false, "only show what would be adopted")
+	adopt.PersistentFlags().BoolVarP(&assumeYes, "yes", "y", false, "don't ask for confirmation")
+	adopt.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
+}
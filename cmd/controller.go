@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	gocontext "context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/armory/spinnaker-tools/internal/pkg/controller"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var controllerServer string
+var controllerNamespace string
+var controllerConfigMap string
+var controllerListenAddress string
+var controllerResyncPeriod time.Duration
+
+// runController keeps service accounts and their RBAC in sync with a spec, continuously
+var runController = &cobra.Command{
+	Use:   "controller",
+	Short: "Continuously keep Spinnaker service accounts and their RBAC in sync with a spec",
+	Long: `Runs until stopped, usually in the cluster (see deploy/controller.yaml), and:
+	* reads a spec of service accounts from the spec.yaml key of a ConfigMap
+	* watches Namespaces, ServiceAccounts created by this tool, and the ConfigMap
+	* applies the ServiceAccounts, Roles, RoleBindings, ClusterRoles and ClusterRoleBindings that
+	  create-service-account would create, and removes RoleBindings from namespaces that are no longer targets
+	* serves /healthz, /readyz and /metrics`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var client *controller.Client
+		var err error
+		if controllerServer != "" {
+			client = controller.NewClient(controllerServer)
+		} else {
+			client, err = controller.NewInClusterClient()
+			if err != nil {
+				color.Red("Unable to connect to the cluster (use --server with `kubectl proxy` outside of a cluster)")
+				color.Red(err.Error())
+				os.Exit(1)
+			}
+		}
+
+		if controllerNamespace == "" {
+			controllerNamespace, err = controller.InClusterNamespace()
+			if err != nil {
+				color.Red("Unable to tell which namespace the controller runs in, use --namespace")
+				color.Red(err.Error())
+				os.Exit(1)
+			}
+		}
+
+		ctx, stop := signal.NotifyContext(gocontext.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		r := &controller.Reconciler{
+			Client:    client,
+			Namespace: controllerNamespace,
+			ConfigMap: controllerConfigMap,
+			Metrics:   &controller.Metrics{},
+		}
+		color.Blue("Reconciling service accounts in ConfigMap %s/%s ...", controllerNamespace, controllerConfigMap)
+		err = controller.Run(ctx, r, controller.Options{
+			ListenAddress: controllerListenAddress,
+			ResyncPeriod:  controllerResyncPeriod,
+		})
+		if err != nil {
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(runController)
+
+	runController.PersistentFlags().StringVar(&controllerServer, "server", "", "API server URL without authentication, such as kubectl proxy (defaults to the cluster the controller runs in)")
+	runController.PersistentFlags().StringVarP(&controllerNamespace, "namespace", "n", "", "namespace of the spec ConfigMap (defaults to the namespace the controller runs in)")
+	runController.PersistentFlags().StringVar(&controllerConfigMap, "config-map", "spinnaker-tools", "name of the ConfigMap holding the spec, under the key spec.yaml")
+	runController.PersistentFlags().StringVar(&controllerListenAddress, "listen-address", ":8080", "address to serve /healthz, /readyz and /metrics on")
+	runController.PersistentFlags().DurationVar(&controllerResyncPeriod, "resync-period", 10*time.Minute, "how often to reconcile even without changes, to undo drift")
+}
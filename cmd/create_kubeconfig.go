@@ -19,6 +19,8 @@ import (
 	"github.com/armory/spinnaker-tools/internal/pkg/debug"
 	"github.com/armory/spinnaker-tools/internal/pkg/k8s"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
@@ -29,7 +31,10 @@ var createKubeconfig = &cobra.Command{
 	Use:   "create-kubeconfig",
 	Short: "Create a kubeconfig from an existing Service Account",
 	Long: `Given a Kubernetes service acount, will create the following:
-	* kubeconfig file with credentials for the ServiceAccount`,
+	* kubeconfig file with credentials (a token, or a client certificate) for the ServiceAccount
+	* (optionally) the Spinnaker Kubernetes account using the kubeconfig, as YAML, a Halyard command, or a
+	  SpinnakerService patch for the Spinnaker Operator
+	* (optionally) a Secret holding the kubeconfig, in the cluster Spinnaker runs in`,
 	Run: func(cmd *cobra.Command, args []string) {
 
 		// Create a debug context
@@ -51,10 +56,34 @@ var createKubeconfig = &cobra.Command{
 			os.Exit(1)
 		}
 
+		var spinnakerCluster k8s.Cluster
+		if wantKubeconfigSecret() {
+			spinnakerCluster = defineSpinnakerCluster(ctx)
+		}
+
 		sa := k8s.ServiceAccount{
 			Namespace:          namespace,
 			ServiceAccountName: serviceAccountName,
 			TargetNamespaces:   nil,
+			Token: k8s.TokenOptions{
+				Duration:   tokenDuration,
+				SecretName: tokenSecretName,
+			},
+		}
+
+		if len(tokenAudiences) != 0 {
+			sa.Token.Audiences = strings.Split(tokenAudiences, ",")
+		}
+
+		sa.Certificate = k8s.CertificateOptions{
+			Duration:     certificateDuration,
+			Timeout:      certificateTimeout,
+			PollInterval: certificatePollInterval,
+		}
+		sa.Credentials, err = k8s.ParseCredentialType(credentialType)
+		if err != nil {
+			color.Red(err.Error())
+			os.Exit(1)
 		}
 
 		// TODO: Figure out which need pointers and which don't, and remove those that don't
@@ -68,6 +97,11 @@ var createKubeconfig = &cobra.Command{
 			os.Exit(1)
 		}
 
+		var accountName string
+		if wantSpinnakerAccount() {
+			accountName = defineSpinnakerAccountName(cluster, sa)
+		}
+
 		f, serr, err := cluster.DefineKubeconfig(destKubeconfig, &sa, verbose)
 		if err != nil || serr != "" {
 			color.Red("Defining kubeconfig failed, exiting")
@@ -76,7 +110,7 @@ var createKubeconfig = &cobra.Command{
 			os.Exit(1)
 		}
 
-		o, serr, err := cluster.CreateKubeconfigUsingKubectl(ctx, f, sa, verbose)
+		o, serr, err := cluster.CreateKubeconfig(ctx, f, sa, verbose)
 		if err != nil || serr != "" {
 			color.Red("Creating Kubeconfig failed, exiting")
 			color.Red(serr)
@@ -84,6 +118,14 @@ var createKubeconfig = &cobra.Command{
 			os.Exit(1)
 		}
 		color.Green("Created kubeconfig file at %s", o)
+
+		if wantKubeconfigSecret() {
+			storeKubeconfigSecret(ctx, spinnakerCluster, sa, o)
+		}
+
+		if wantSpinnakerAccount() {
+			outputSpinnakerAccount(accountName, sa, o)
+		}
 	},
 }
 
@@ -97,6 +139,14 @@ func init() {
 	createKubeconfig.PersistentFlags().StringVarP(&context, "context", "c", "", "kubectl context to use")
 	createKubeconfig.PersistentFlags().StringVarP(&namespace, "namespace", "n", "", "namespace to create service account in")
 	createKubeconfig.PersistentFlags().StringVarP(&serviceAccountName, "service-account-name", "s", "", "service account name")
+	createKubeconfig.PersistentFlags().DurationVar(&tokenDuration, "token-duration", 8760*time.Hour, "requested lifetime of the service account token, on clusters without token secrets (0 for the server default)")
+	createKubeconfig.PersistentFlags().StringVar(&tokenAudiences, "token-audience", "", "comma-separated list of audiences of the service account token, on clusters without token secrets")
+	createKubeconfig.PersistentFlags().StringVar(&tokenSecretName, "token-secret-name", "", "read the token from this service-account-token secret")
+	createKubeconfig.PersistentFlags().StringVar(&credentialType, "credential-type", "token", "credentials to put in the kubeconfig: token, or certificate (issued through a CertificateSigningRequest)")
+	createKubeconfig.PersistentFlags().DurationVar(&certificateDuration, "certificate-duration", 720*time.Hour, "requested lifetime of the client certificate (0 for the signer default)")
+	createKubeconfig.PersistentFlags().DurationVar(&certificateTimeout, "certificate-timeout", 2*time.Minute, "how long to wait for the client certificate to be issued")
+	createKubeconfig.PersistentFlags().DurationVar(&certificatePollInterval, "certificate-poll-interval", 2*time.Second, "how often to check whether the client certificate has been issued")
+	addSpinnakerAccountFlags(createKubeconfig)
 	createKubeconfig.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
 
 }
@@ -15,11 +15,14 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"github.com/armory/spinnaker-tools/internal/pkg/debug"
 	"github.com/armory/spinnaker-tools/internal/pkg/k8s"
+	"github.com/armory/spinnaker-tools/internal/pkg/utils"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
@@ -31,6 +34,26 @@ var context string
 var namespace string
 var serviceAccountName string
 var targetNamespaces string
+var targetNamespaceSelector string
+var tokenDuration time.Duration
+var tokenAudiences string
+var tokenSecretName string
+var longLivedToken bool
+var tokenTimeout time.Duration
+var tokenPollInterval time.Duration
+var credentialType string
+var role string
+var allowSecrets bool
+var readOnly bool
+var namespaceBinding string
+var createMissingNamespaces string
+var certificateDuration time.Duration
+var certificateTimeout time.Duration
+var certificatePollInterval time.Duration
+var templateDir string
+var templateValues []string
+var dryRun string
+var manifestOutput string
 var verbose bool
 
 // createServiceAccount creates a service account and kubeconfig
@@ -39,8 +62,20 @@ var createServiceAccount = &cobra.Command{
 	Short: "Create a service account and Kubeconfig",
 	Long: `Given a Kubernetes kubeconfig and context, will create the following:
 	* Kubernetes ServiceAccount
-	* Kubernetes ClusterRole granting the service account access to cluster-admin
-	* kubeconfig file with credentials for the ServiceAccount`,
+	* Kubernetes ClusterRoleBinding granting the service account cluster-admin, or a ClusterRole (or Roles
+	  in each target namespace) granting it only what the chosen role preset allows
+	* (with target namespaces) RoleBindings in each, to those Roles, a single shared ClusterRole, or the
+	  built-in edit, admin or view ClusterRole
+	* (optionally) a long-lived service-account-token Secret for the ServiceAccount
+	* kubeconfig file with credentials (a token, or a client certificate) for the ServiceAccount
+	* (optionally) the Spinnaker Kubernetes account using the kubeconfig, as YAML, a Halyard command, or a
+	  SpinnakerService patch for the Spinnaker Operator
+	* (optionally) a Secret holding the kubeconfig, in the cluster Spinnaker runs in
+If the service account already exists, what would change is shown first, and only objects that differ
+are applied (after confirming, if any existing ones change); bindings left over from earlier runs, such
+as in namespaces that are no longer target namespaces, are deleted
+With --dry-run, nothing is created: the manifests are printed (or written to --manifest-output), along
+with the kubeconfig that would be produced, and with --dry-run=server validated by the API server`,
 	Run: func(cmd *cobra.Command, args []string) {
 
 		// Create a debug context
@@ -53,6 +88,11 @@ var createServiceAccount = &cobra.Command{
 			KubeconfigFile: sourceKubeconfig,
 			Context:        k8s.ClusterContext{ContextName: context},
 		}
+		if dryRun != "" && dryRun != "client" && dryRun != "server" {
+			color.Red("--dry-run must be client or server")
+			os.Exit(1)
+		}
+
 		// TODO: change parameters
 		serr, err := cluster.DefineCluster(ctx, verbose)
 		if err != nil || serr != "" {
@@ -62,6 +102,11 @@ var createServiceAccount = &cobra.Command{
 			os.Exit(1)
 		}
 
+		var spinnakerCluster k8s.Cluster
+		if wantKubeconfigSecret() && dryRun == "" {
+			spinnakerCluster = defineSpinnakerCluster(ctx)
+		}
+
 		sa := k8s.ServiceAccount{
 			Namespace:          namespace,
 			ServiceAccountName: serviceAccountName,
@@ -71,6 +116,69 @@ var createServiceAccount = &cobra.Command{
 		if len(targetNamespaces) != 0 {
 			sa.TargetNamespaces = strings.Split(targetNamespaces, ",")
 		}
+		if targetNamespaceSelector != "" {
+			if len(targetNamespaces) != 0 {
+				color.Red("--target-namespaces cannot be combined with --target-namespace-selector")
+				os.Exit(1)
+			}
+			sa.TargetNamespaceSelector = targetNamespaceSelector
+		}
+
+		sa.Token = k8s.TokenOptions{
+			Duration:     tokenDuration,
+			SecretName:   tokenSecretName,
+			CreateSecret: longLivedToken,
+			Timeout:      tokenTimeout,
+			PollInterval: tokenPollInterval,
+		}
+		if len(tokenAudiences) != 0 {
+			sa.Token.Audiences = strings.Split(tokenAudiences, ",")
+		}
+
+		sa.Role, err = k8s.ParseRole(role)
+		if err != nil {
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		if readOnly {
+			if sa.Role != "" && sa.Role != k8s.ReadOnlyRole {
+				color.Red("--read-only cannot be combined with --role %s", sa.Role)
+				os.Exit(1)
+			}
+			sa.Role = k8s.ReadOnlyRole
+		}
+		sa.AllowSecrets = allowSecrets
+		sa.NamespaceBinding, err = k8s.ParseNamespaceBinding(namespaceBinding)
+		if err != nil {
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		sa.MissingNamespaces, err = k8s.ParseMissingNamespacePolicy(createMissingNamespaces)
+		if err != nil {
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		if sa.NamespaceBinding.BuiltIn() && sa.Role != "" {
+			color.Red("--namespace-binding %s grants the built-in ClusterRole, and cannot be combined with --role or --read-only", sa.NamespaceBinding)
+			os.Exit(1)
+		}
+
+		sa.Certificate = k8s.CertificateOptions{
+			Duration:     certificateDuration,
+			Timeout:      certificateTimeout,
+			PollInterval: certificatePollInterval,
+		}
+		sa.Credentials, err = k8s.ParseCredentialType(credentialType)
+		if err != nil {
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+
+		sa.Templates, err = defineTemplates()
+		if err != nil {
+			color.Red(err.Error())
+			os.Exit(1)
+		}
 
 		// TODO: Figure out which need pointers and which don't, and remove those that don't
 		// TODO: each of these should have some error handling built in
@@ -83,6 +191,16 @@ var createServiceAccount = &cobra.Command{
 			os.Exit(1)
 		}
 
+		if sa.Token.CreateSecret && sa.Token.SecretName == "" {
+			sa.Token.SecretName = sa.ServiceAccountName + "-token"
+		}
+		sa.Provenance = cluster.NewProvenance(commandLine(), verbose)
+
+		var accountName string
+		if wantSpinnakerAccount() && dryRun == "" {
+			accountName = defineSpinnakerAccountName(cluster, sa)
+		}
+
 		f, serr, err := cluster.DefineKubeconfig(destKubeconfig, &sa, verbose)
 		if err != nil || serr != "" {
 			color.Red("Defining kubeconfig failed, exiting")
@@ -91,6 +209,31 @@ var createServiceAccount = &cobra.Command{
 			os.Exit(1)
 		}
 
+		diff, serr, err := cluster.DiffServiceAccount(&sa, verbose)
+		if err != nil {
+			color.Red("Comparing with the cluster failed, exiting")
+			color.Red(serr)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		color.Blue("Changes to context %s:", cluster.Context.ContextName)
+		for _, line := range diff.Summary() {
+			fmt.Println("  * " + line)
+		}
+
+		if dryRun != "" {
+			dryRunServiceAccount(cluster, sa, f)
+			return
+		}
+
+		if diff.NeedsConfirmation() {
+			serr, err = k8s.ConfirmChanges(assumeYes)
+			if err != nil {
+				color.Red(serr)
+				os.Exit(1)
+			}
+		}
+
 		serr, err = cluster.CreateServiceAccount(ctx, &sa, verbose)
 		if err != nil || serr != "" {
 			color.Red("Creating service account failed, exiting")
@@ -99,7 +242,17 @@ var createServiceAccount = &cobra.Command{
 			os.Exit(1)
 		}
 
-		o, serr, err := cluster.CreateKubeconfigUsingKubectl(ctx, f, sa, verbose)
+		if sa.Credentials == k8s.TokenCredentials {
+			serr, err = cluster.WaitForToken(ctx, sa, verbose)
+			if err != nil || serr != "" {
+				color.Red("Waiting for service account token failed, exiting")
+				color.Red(serr)
+				color.Red(err.Error())
+				os.Exit(1)
+			}
+		}
+
+		o, serr, err := cluster.CreateKubeconfig(ctx, f, sa, verbose)
 		if err != nil || serr != "" {
 			color.Red("Creating Kubeconfig failed, exiting")
 			color.Red(serr)
@@ -107,6 +260,14 @@ var createServiceAccount = &cobra.Command{
 			os.Exit(1)
 		}
 		color.Green("Created kubeconfig file at %s", o)
+
+		if wantKubeconfigSecret() {
+			storeKubeconfigSecret(ctx, spinnakerCluster, sa, o)
+		}
+
+		if wantSpinnakerAccount() {
+			outputSpinnakerAccount(accountName, sa, o)
+		}
 	},
 }
 
@@ -122,6 +283,97 @@ func init() {
 	createServiceAccount.PersistentFlags().StringVarP(&serviceAccountName, "service-account-name", "s", "", "service account name")
 	// createServiceAccount.PersistentFlags().BoolVarP(&notAdmin, "select-namespaces", "T", false, "don't create service account as cluster-admin")
 	createServiceAccount.PersistentFlags().StringVarP(&targetNamespaces, "target-namespaces", "t", "", "comma-separated list of namespaces to deploy to")
+	createServiceAccount.PersistentFlags().StringVar(&targetNamespaceSelector, "target-namespace-selector", "", "label selector for namespaces to deploy to (e.g. team=payments); run sync to keep up with namespaces added later")
+	createServiceAccount.PersistentFlags().StringVar(&role, "role", "", "role preset to grant the service account: cluster-admin, spinnaker-deployer, or read-only (prompted for if not given)")
+	createServiceAccount.PersistentFlags().BoolVar(&readOnly, "read-only", false, "only allow the service account to get, list and watch (same as --role read-only)")
+	createServiceAccount.PersistentFlags().StringVar(&createMissingNamespaces, "create-missing-namespaces", "prompt", "what to do about target namespaces that don't exist: prompt (fails without a terminal), fail, or create")
+	createServiceAccount.PersistentFlags().StringVar(&namespaceBinding, "namespace-binding", "", "how to grant access to target namespaces: role (a Role in each), shared-cluster-role (one ClusterRole, bound in each), or the built-in edit, admin or view ClusterRole (prompted for if not given)")
+	createServiceAccount.PersistentFlags().BoolVar(&allowSecrets, "allow-secrets", false, "allow role presets other than cluster-admin to read and manage Secrets (without it, pods the service account creates can still mount Secrets in their namespace)")
+	createServiceAccount.PersistentFlags().DurationVar(&tokenDuration, "token-duration", 8760*time.Hour, "requested lifetime of the service account token, on clusters without token secrets (0 for the server default)")
+	createServiceAccount.PersistentFlags().StringVar(&tokenAudiences, "token-audience", "", "comma-separated list of audiences of the service account token, on clusters without token secrets")
+	createServiceAccount.PersistentFlags().BoolVar(&longLivedToken, "long-lived-token", false, "create a non-expiring service-account-token secret and use its token")
+	createServiceAccount.PersistentFlags().StringVar(&tokenSecretName, "token-secret-name", "", "name of the service-account-token secret (defaults to <service-account-name>-token)")
+	createServiceAccount.PersistentFlags().DurationVar(&tokenTimeout, "token-timeout", 2*time.Minute, "how long to wait for a token for the service account to become available")
+	createServiceAccount.PersistentFlags().DurationVar(&tokenPollInterval, "token-poll-interval", 2*time.Second, "how often to check whether a token for the service account is available")
+	createServiceAccount.PersistentFlags().StringVar(&credentialType, "credential-type", "token", "credentials to put in the kubeconfig: token, or certificate (issued through a CertificateSigningRequest)")
+	createServiceAccount.PersistentFlags().DurationVar(&certificateDuration, "certificate-duration", 720*time.Hour, "requested lifetime of the client certificate (0 for the signer default)")
+	createServiceAccount.PersistentFlags().DurationVar(&certificateTimeout, "certificate-timeout", 2*time.Minute, "how long to wait for the client certificate to be issued")
+	createServiceAccount.PersistentFlags().DurationVar(&certificatePollInterval, "certificate-poll-interval", 2*time.Second, "how often to check whether the client certificate has been issued")
+	createServiceAccount.PersistentFlags().StringVar(&templateDir, "template-dir", "", "directory of templates replacing the built-in manifests, named <template>.yaml (e.g. namespaceRoleBinding.yaml)")
+	createServiceAccount.PersistentFlags().StringArrayVar(&templateValues, "set", nil, "key=value available to custom templates as {{ .Values.key }} (can be repeated)")
+	createServiceAccount.PersistentFlags().StringVar(&dryRun, "dry-run", "", "don't change anything, only show the manifests and kubeconfig: client, or server to also validate the manifests with the API server")
+	createServiceAccount.PersistentFlags().Lookup("dry-run").NoOptDefVal = "client"
+	createServiceAccount.PersistentFlags().StringVar(&manifestOutput, "manifest-output", "", "with --dry-run, file to write the manifests to instead of printing them")
+	createServiceAccount.PersistentFlags().BoolVarP(&assumeYes, "yes", "y", false, "don't ask for confirmation before changing objects that already exist")
+	addSpinnakerAccountFlags(createServiceAccount)
 	createServiceAccount.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
 
 }
+
+// Builds the template options from --template-dir and --set
+func defineTemplates() (k8s.TemplateOptions, error) {
+	values, err := k8s.ParseTemplateValues(templateValues)
+	if err != nil {
+		return k8s.TemplateOptions{}, err
+	}
+	if templateDir != "" {
+		info, err := os.Stat(templateDir)
+		if err != nil {
+			return k8s.TemplateOptions{}, err
+		}
+		if !info.IsDir() {
+			return k8s.TemplateOptions{}, errors.New("--template-dir " + templateDir + " is not a directory")
+		}
+	}
+	return k8s.TemplateOptions{Dir: templateDir, Values: values}, nil
+}
+
+// Shows what create-service-account would do, without changing anything in the cluster:
+// * The manifests (namespaces, ServiceAccount, token Secret, and RBAC), as one multi-document YAML
+// * The kubeconfig, with placeholders for the credentials
+// * With --dry-run=server, whether the API server (and its admission webhooks) accepts the manifests
+func dryRunServiceAccount(cluster k8s.Cluster, sa k8s.ServiceAccount, kubeconfigFile string) {
+	manifests, err := k8s.ServiceAccountManifests(sa, verbose)
+	if err != nil {
+		color.Red("Unable to render manifests for service account, exiting")
+		color.Red(err.Error())
+		os.Exit(1)
+	}
+	manifest := strings.Join(manifests, "")
+
+	if manifestOutput != "" {
+		if err := utils.WriteFileAtomic(manifestOutput, []byte(manifest), 0644); err != nil {
+			color.Red("Unable to write manifests to %s", manifestOutput)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		color.Green("Wrote manifests to %s", manifestOutput)
+	} else {
+		color.Blue("Manifests that would be applied to context %s:", cluster.Context.ContextName)
+		fmt.Print(manifest)
+	}
+
+	kc, serr, err := cluster.DescribeKubeconfig(sa)
+	if err != nil {
+		color.Red(serr)
+		color.Red(err.Error())
+		os.Exit(1)
+	}
+	color.Blue("Kubeconfig that would be written to %s:", kubeconfigFile)
+	fmt.Print(kc)
+
+	if dryRun == "server" {
+		color.Blue("Validating manifests with the API server ...")
+		skipped, serr, err := cluster.ServerDryRun(sa, manifests, verbose)
+		for _, object := range skipped {
+			color.Yellow("Not validated, its namespace doesn't exist yet: %s", object)
+		}
+		if err != nil {
+			color.Red(serr)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		color.Green("The API server accepted the manifests")
+	}
+	color.Yellow("Dry run: nothing was changed")
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/armory/spinnaker-tools/internal/pkg/debug"
+	"github.com/armory/spinnaker-tools/internal/pkg/k8s"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var deleteNamespaces bool
+var deleteDryRun bool
+var assumeYes bool
+
+// deleteServiceAccount removes what create-service-account created for a service account
+var deleteServiceAccount = &cobra.Command{
+	Use:   "delete-service-account",
+	Short: "Delete a service account, and everything create-service-account created for it",
+	Long: `Given a Kubernetes service account created with create-service-account, will delete the following,
+after showing what will be deleted and asking for confirmation:
+	* RoleBindings for it in any namespace, and the Roles or shared ClusterRole they bind
+	* ClusterRoleBindings for it (<namespace>-<service account>-admin, or -<role preset>), and the role
+	  preset ClusterRoles they bind
+	* token Secrets for it, and the ServiceAccount
+	* (with --delete-namespaces) namespaces create-service-account created for it; other namespaces are
+	  never deleted`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Create a debug context
+		ctx, err := debug.NewContext(true)
+		if err != nil {
+			fmt.Println("TODO: This needs error handling")
+		}
+
+		cluster := k8s.Cluster{
+			KubeconfigFile: sourceKubeconfig,
+			Context:        k8s.ClusterContext{ContextName: context},
+		}
+		serr, err := cluster.DefineCluster(ctx, verbose)
+		if err != nil || serr != "" {
+			color.Red("Defining cluster failed, exiting")
+			color.Red(serr)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+
+		sa := k8s.ServiceAccount{
+			Namespace:          namespace,
+			ServiceAccountName: serviceAccountName,
+		}
+		// Given both, the service account may already be gone, with what was created for it left behind
+		if sa.Namespace == "" || sa.ServiceAccountName == "" {
+			serr, err = cluster.SelectServiceAccount(ctx, &sa, verbose)
+			if err != nil || serr != "" {
+				color.Red("Selecting service account failed, exiting")
+				color.Red(serr)
+				color.Red(err.Error())
+				os.Exit(1)
+			}
+		}
+
+		color.Blue("Finding what was created for service account %s ...", sa.ServiceAccountName)
+		objs, serr, err := cluster.FindServiceAccountObjects(sa, verbose)
+		if err != nil {
+			color.Red(serr)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+
+		color.Blue("In context %s:", cluster.Context.ContextName)
+		for _, line := range k8s.DeletionSummary(objs, deleteNamespaces) {
+			fmt.Println("  * " + line)
+		}
+		if deleteDryRun {
+			color.Yellow("Dry run: nothing was deleted")
+			return
+		}
+
+		serr, err = k8s.ConfirmDeletion(assumeYes)
+		if err != nil {
+			color.Red(serr)
+			os.Exit(1)
+		}
+
+		serr, err = cluster.DeleteServiceAccount(ctx, objs, deleteNamespaces, verbose)
+		if err != nil {
+			color.Red("Deleting service account failed, exiting")
+			color.Red(serr)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		color.Green("Deleted service account %s", sa.ServiceAccountName)
+		color.Yellow("Kubeconfigs and Spinnaker accounts using it no longer work, and should be removed")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(deleteServiceAccount)
+
+	deleteServiceAccount.PersistentFlags().StringVarP(&sourceKubeconfig, "kubeconfig", "i", "", "kubeconfig to start with")
+	deleteServiceAccount.PersistentFlags().StringVarP(&context, "context", "c", "", "kubectl context to use")
+	deleteServiceAccount.PersistentFlags().StringVarP(&namespace, "namespace", "n", "", "namespace of the service account")
+	deleteServiceAccount.PersistentFlags().StringVarP(&serviceAccountName, "service-account-name", "s", "", "service account name")
+	deleteServiceAccount.PersistentFlags().BoolVar(&deleteNamespaces, "delete-namespaces", false, "also delete the namespaces create-service-account created for the service account, and everything in them")
+	deleteServiceAccount.PersistentFlags().BoolVar(&deleteDryRun, "dry-run", false, "only show what would be deleted")
+	deleteServiceAccount.PersistentFlags().BoolVarP(&assumeYes, "yes", "y", false, "don't ask for confirmation")
+	deleteServiceAccount.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
+}
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/armory/spinnaker-tools/internal/pkg/debug"
+	"github.com/armory/spinnaker-tools/internal/pkg/k8s"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+var describeOutput string
+
+// describeServiceAccount shows what a service account can do
+var describeServiceAccount = &cobra.Command{
+	Use:   "describe-service-account",
+	Short: "Show what a Service Account can do, for access reviews",
+	Long: `Given a Kubernetes service account (created by this tool or not), will show:
+	* every RoleBinding and ClusterRoleBinding referencing it, directly or through the groups every
+	  service account is in, and the Role or ClusterRole each binds
+	* the verbs it is allowed on each resource, cluster-wide and in each namespace it has RoleBindings in
+	* where that differs from what the API server reports it is allowed, with a SelfSubjectRulesReview
+	  made as the service account (using a short-lived token)`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if describeOutput != "table" && describeOutput != "json" && describeOutput != "yaml" {
+			color.Red("--output must be table, json or yaml")
+			os.Exit(1)
+		}
+		// Keep stdout for the description, so it can be piped
+		color.Output = color.Error
+
+		// Create a debug context
+		ctx, err := debug.NewContext(true)
+		if err != nil {
+			fmt.Println("TODO: This needs error handling")
+		}
+
+		cluster := k8s.Cluster{
+			KubeconfigFile: sourceKubeconfig,
+			Context:        k8s.ClusterContext{ContextName: context},
+		}
+		serr, err := cluster.DefineCluster(ctx, verbose)
+		if err != nil || serr != "" {
+			color.Red("Defining cluster failed, exiting")
+			color.Red(serr)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+
+		sa := k8s.ServiceAccount{
+			Namespace:          namespace,
+			ServiceAccountName: serviceAccountName,
+		}
+		serr, err = cluster.SelectServiceAccount(ctx, &sa, verbose)
+		if err != nil || serr != "" {
+			color.Red("Selecting service account failed, exiting")
+			color.Red(serr)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+
+		color.Blue("Finding what service account %s can do ...", sa.ServiceAccountName)
+		d, serr, err := cluster.DescribeServiceAccount(sa, verbose)
+		if err != nil {
+			color.Red("Describing service account failed, exiting")
+			color.Red(serr)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+
+		switch describeOutput {
+		case "json":
+			b, _ := json.MarshalIndent(d, "", "  ")
+			fmt.Println(string(b))
+		case "yaml":
+			b, _ := yaml.Marshal(d)
+			fmt.Print(string(b))
+		default:
+			printServiceAccountDescription(d)
+		}
+	},
+}
+
+// Prints the bindings, then a permission matrix for each scope, kubectl style
+func printServiceAccountDescription(d k8s.ServiceAccountDescription) {
+	fmt.Printf("Service account %s/%s in context %s\n\n", d.Namespace, d.Name, d.Context)
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 4, 3, ' ', 0)
+	fmt.Fprintln(w, "BINDING\tROLE\tSHARED")
+	for _, b := range d.Bindings {
+		shared := "no"
+		if b.Shared {
+			shared = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", b.Object, b.Role, shared)
+	}
+	w.Flush()
+	for _, role := range d.MissingRoles {
+		color.New(color.FgYellow).Fprintf(os.Stdout, "%s doesn't exist, so grants nothing\n", role)
+	}
+
+	for _, m := range d.Permissions {
+		if m.Namespace == "" {
+			fmt.Println("\nCluster-wide (and in every namespace):")
+		} else {
+			fmt.Printf("\nNamespace %s (including cluster-wide):\n", m.Namespace)
+		}
+		if len(m.Resources) == 0 && len(m.NonResourceURLs) == 0 {
+			fmt.Println("  nothing")
+		} else {
+			printPermissionMatrix(m)
+		}
+
+		if m.Namespace == "" {
+			continue
+		}
+		switch {
+		case !d.Reviewed:
+			color.New(color.FgYellow).Fprintf(os.Stdout, "Not cross-checked: %s\n", d.ReviewError)
+		case len(m.Mismatches) == 0:
+			color.New(color.FgGreen).Fprintln(os.Stdout, "Matches what the API server reports (SelfSubjectRulesReview)")
+		default:
+			color.New(color.FgYellow).Fprintln(os.Stdout, "Differs from what the API server reports (SelfSubjectRulesReview):")
+			for _, mismatch := range m.Mismatches {
+				fmt.Println("  * " + mismatch)
+			}
+		}
+		if m.ReviewIncomplete {
+			color.New(color.FgYellow).Fprintln(os.Stdout, "The API server says its rules may be incomplete, as other authorizers can allow more")
+		}
+	}
+	fmt.Printf("\n%s: allowed; %s: only for the resource names listed in the role\n", k8s.AllAccess, k8s.SomeAccess)
+}
+
+func printPermissionMatrix(m k8s.PermissionMatrix) {
+	w := tabwriter.NewWriter(os.Stdout, 1, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "RESOURCE\t%s\n", strings.ToUpper(strings.Join(m.Verbs, "\t")))
+	for _, row := range m.Resources {
+		cells := []string{row.Resource}
+		for _, verb := range m.Verbs {
+			cell := row.Verbs[verb]
+			if cell == "" {
+				cell = "-"
+			}
+			cells = append(cells, cell)
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+	w.Flush()
+
+	for _, row := range m.NonResourceURLs {
+		var verbs []string
+		for _, verb := range append(append([]string{}, m.Verbs...), "*") {
+			if row.Verbs[verb] != "" && !contains(verbs, verb) {
+				verbs = append(verbs, verb)
+			}
+		}
+		fmt.Printf("URL %s: %s\n", row.Resource, strings.Join(verbs, ", "))
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	rootCmd.AddCommand(describeServiceAccount)
+
+	describeServiceAccount.PersistentFlags().StringVarP(&sourceKubeconfig, "kubeconfig", "i", "", "kubeconfig to start with")
+	describeServiceAccount.PersistentFlags().StringVarP(&context, "context", "c", "", "kubectl context to use")
+	describeServiceAccount.PersistentFlags().StringVarP(&namespace, "namespace", "n", "", "namespace of the service account (prompted for if not given)")
+	describeServiceAccount.PersistentFlags().StringVarP(&serviceAccountName, "service-account-name", "s", "", "service account name (prompted for if not given)")
+	describeServiceAccount.PersistentFlags().StringVarP(&describeOutput, "output", "o", "table", "output format: table, json or yaml")
+	describeServiceAccount.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
+}
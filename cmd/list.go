@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/armory/spinnaker-tools/internal/pkg/k8s"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+var listContexts []string
+var listAllContexts bool
+var listOutput string
+
+// listServiceAccounts lists the service accounts created by this tool, in one or more clusters
+var listServiceAccounts = &cobra.Command{
+	Use:   "list",
+	Short: "List the Service Accounts created by this tool",
+	Long: `Given a kubeconfig, and one or more contexts (the current context by default), will list the
+service accounts create-service-account created in each cluster, with:
+	* their namespace and age
+	* the role preset they were granted, cluster-wide or in target namespaces
+	* the target namespaces they have access to (and the selector they were created with)
+	* the credential in the last kubeconfig created for them, and its age`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if listOutput != "table" && listOutput != "json" && listOutput != "yaml" {
+			color.Red("--output must be table, json or yaml")
+			os.Exit(1)
+		}
+		// Keep stdout for the list, so it can be piped
+		color.Output = color.Error
+
+		kubeconfigFile, contexts, current, err := k8s.KubeconfigContexts(sourceKubeconfig)
+		if err != nil {
+			color.Red("Unable to read kubeconfig %s", kubeconfigFile)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		if !listAllContexts {
+			contexts = listContexts
+			if len(contexts) == 0 {
+				contexts = []string{current}
+			}
+		}
+
+		failed := false
+		infos := []k8s.ServiceAccountInfo{}
+		for _, contextName := range contexts {
+			cluster := k8s.Cluster{
+				KubeconfigFile: kubeconfigFile,
+				Context:        k8s.ClusterContext{ContextName: contextName},
+			}
+			found, serr, err := cluster.ListServiceAccounts(verbose)
+			if err != nil {
+				failed = true
+				color.Red("Listing service accounts in context %s failed", contextName)
+				color.Red(serr)
+				color.Red(err.Error())
+				continue
+			}
+			infos = append(infos, found...)
+		}
+
+		switch listOutput {
+		case "json":
+			b, _ := json.MarshalIndent(infos, "", "  ")
+			fmt.Println(string(b))
+		case "yaml":
+			b, _ := yaml.Marshal(infos)
+			fmt.Print(string(b))
+		default:
+			printServiceAccountTable(infos, time.Now())
+		}
+
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// Prints the service accounts as a table, kubectl style
+func printServiceAccountTable(infos []k8s.ServiceAccountInfo, now time.Time) {
+	w := tabwriter.NewWriter(os.Stdout, 1, 4, 3, ' ', 0)
+	fmt.Fprintln(w, "CONTEXT\tNAMESPACE\tNAME\tAGE\tROLE\tSCOPE\tTARGET NAMESPACES\tCREDENTIAL\tCREDENTIAL AGE")
+	for _, info := range infos {
+		targets := "-"
+		if info.Scope != "cluster" {
+			targets = strings.Join(info.TargetNamespaces, ",")
+			if info.TargetNamespaceSelector != "" {
+				targets = info.TargetNamespaceSelector + " (" + targets + ")"
+			}
+		}
+		credential, credentialAge := "unknown", "-"
+		if info.Credential != "" {
+			credential = info.Credential
+		}
+		if info.CredentialIssued != nil {
+			credentialAge = age(now.Sub(*info.CredentialIssued))
+		}
+		if info.CredentialExpires != nil && now.After(*info.CredentialExpires) {
+			credential += " (expired)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", info.Context, info.Namespace, info.Name, age(now.Sub(info.Created)),
+			info.Role, info.Scope, targets, credential, credentialAge)
+	}
+	w.Flush()
+}
+
+// Formats a duration the way kubectl shows ages: 45s, 12m, 5h, 20d
+func age(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(listServiceAccounts)
+
+	listServiceAccounts.PersistentFlags().StringVarP(&sourceKubeconfig, "kubeconfig", "i", "", "kubeconfig to start with")
+	listServiceAccounts.PersistentFlags().StringArrayVarP(&listContexts, "context", "c", nil, "kubectl context to list service accounts in (can be repeated; defaults to the current context)")
+	listServiceAccounts.PersistentFlags().BoolVar(&listAllContexts, "all-contexts", false, "list service accounts in every context of the kubeconfig")
+	listServiceAccounts.PersistentFlags().StringVarP(&listOutput, "output", "o", "table", "output format: table, json or yaml")
+	listServiceAccounts.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
+}
@@ -17,6 +17,9 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+
+	"github.com/armory/spinnaker-tools/internal/pkg/version"
 
 	// homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
@@ -25,9 +28,10 @@ import (
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
-	Use:   "spinnaker-tools",
-	Short: "Spinnaker Utilities",
-	Long:  `Spinnaker Utilities`,
+	Use:     "spinnaker-tools",
+	Short:   "Spinnaker Utilities",
+	Long:    `Spinnaker Utilities`,
+	Version: version.Version,
 	//	Run: func(cmd *cobra.Command, args []string) { },
 }
 
@@ -40,6 +44,11 @@ func Execute() {
 	}
 }
 
+// The command line this was run with, recorded on the objects it creates
+func commandLine() []string {
+	return append([]string{filepath.Base(os.Args[0])}, os.Args[1:]...)
+}
+
 func init() {
 	// rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 }
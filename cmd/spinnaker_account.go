@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/armory/spinnaker-tools/internal/pkg/diagnostics"
+	"github.com/armory/spinnaker-tools/internal/pkg/k8s"
+	"github.com/armory/spinnaker-tools/internal/pkg/spinnaker"
+	"github.com/armory/spinnaker-tools/internal/pkg/utils"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var spinnakerAccountOutput string
+var spinnakerAccountName string
+var onlySpinnakerManaged bool
+var halCommand bool
+var operatorPatch string
+var kubeconfigSecret string
+var kubeconfigSecretKey string
+var spinnakerKubeconfig string
+var spinnakerContext string
+var spinnakerNamespace string
+
+// Flags for the Spinnaker account outputs, shared by the commands that create a kubeconfig
+func addSpinnakerAccountFlags(c *cobra.Command) {
+	c.PersistentFlags().StringVar(&spinnakerAccountOutput, "spinnaker-account-output", "", "file to write the Spinnaker kubernetes account configuration (YAML) to")
+	c.PersistentFlags().BoolVar(&halCommand, "hal-command", false, "print the Halyard command that adds the Spinnaker kubernetes account")
+	c.PersistentFlags().StringVar(&operatorPatch, "operator-patch", "", "SpinnakerService patch file to add the Spinnaker kubernetes account to (created if it doesn't exist)")
+	c.PersistentFlags().StringVar(&spinnakerAccountName, "account-name", "", "Spinnaker account name (defaults to one derived from the cluster name)")
+	c.PersistentFlags().BoolVar(&onlySpinnakerManaged, "only-spinnaker-managed", false, "only show resources deployed by Spinnaker in the Spinnaker account")
+	c.PersistentFlags().StringVar(&kubeconfigSecret, "kubeconfig-secret", "", "name of the Secret in Spinnaker's namespace the Spinnaker account reads the kubeconfig from (instead of a file)")
+	c.PersistentFlags().StringVar(&kubeconfigSecretKey, "kubeconfig-secret-key", "kubeconfig", "key of the kubeconfig in --kubeconfig-secret")
+	c.PersistentFlags().StringVar(&spinnakerContext, "spinnaker-context", "", "kubectl context of the cluster Spinnaker runs in, to store the kubeconfig as --kubeconfig-secret there")
+	c.PersistentFlags().StringVar(&spinnakerKubeconfig, "spinnaker-kubeconfig", "", "kubeconfig for --spinnaker-context")
+	c.PersistentFlags().StringVar(&spinnakerNamespace, "spinnaker-namespace", "spinnaker", "namespace Spinnaker runs in, to store --kubeconfig-secret in")
+}
+
+// Whether the kubeconfig should be stored as a Secret in the cluster Spinnaker runs in
+func wantKubeconfigSecret() bool {
+	return spinnakerContext != ""
+}
+
+// Selects the cluster Spinnaker runs in, to store the kubeconfig Secret in
+// Done before anything is created, so a bad context doesn't leave things half done
+func defineSpinnakerCluster(ctx diagnostics.Handler) k8s.Cluster {
+	if kubeconfigSecret == "" {
+		color.Red("--kubeconfig-secret is required to store the kubeconfig in the Spinnaker cluster, exiting")
+		os.Exit(1)
+	}
+
+	cluster := k8s.Cluster{
+		KubeconfigFile: spinnakerKubeconfig,
+		Context:        k8s.ClusterContext{ContextName: spinnakerContext},
+	}
+	serr, err := cluster.DefineCluster(ctx, verbose)
+	if err != nil || serr != "" {
+		color.Red("Defining Spinnaker cluster failed, exiting")
+		color.Red(serr)
+		color.Red(err.Error())
+		os.Exit(1)
+	}
+	return cluster
+}
+
+// Stores the kubeconfig as a Secret in the cluster Spinnaker runs in, and prints how to reference it
+func storeKubeconfigSecret(ctx diagnostics.Handler, cluster k8s.Cluster, sa k8s.ServiceAccount, kubeconfigFile string) {
+	sa.Provenance = cluster.NewProvenance(commandLine(), verbose)
+	serr, err := cluster.StoreKubeconfigSecret(ctx, sa, kubeconfigFile, spinnakerNamespace, kubeconfigSecret, kubeconfigSecretKey, verbose)
+	if err != nil || serr != "" {
+		color.Red("Storing kubeconfig in Spinnaker cluster failed, exiting")
+		color.Red(serr)
+		color.Red(err.Error())
+		os.Exit(1)
+	}
+	color.Green("Stored kubeconfig in secret %s in namespace %s of %s", kubeconfigSecret, spinnakerNamespace, cluster.Context.ContextName)
+	color.Green("Reference it in Spinnaker as:")
+	fmt.Println(spinnaker.EncryptedFileReference(kubeconfigSecret, kubeconfigSecretKey))
+}
+
+// Whether any Spinnaker account output was asked for
+func wantSpinnakerAccount() bool {
+	return spinnakerAccountOutput != "" || halCommand || operatorPatch != ""
+}
+
+// Works out the Spinnaker account name (from the flag, or the cluster name), and validates it
+// Derived names of read-only accounts are suffixed with `-readonly`
+// Done before anything is created, so an invalid name doesn't leave things half done
+func defineSpinnakerAccountName(cluster k8s.Cluster, sa k8s.ServiceAccount) string {
+	name := spinnakerAccountName
+	if name == "" {
+		name = spinnaker.AccountName(cluster.Context.ClusterName)
+		if sa.ReadOnly() {
+			name += "-readonly"
+		}
+	}
+	if err := spinnaker.ValidateAccountName(name); err != nil {
+		color.Red("Defining Spinnaker account failed, exiting")
+		color.Red(err.Error())
+		color.Red("Use --account-name to choose a valid name")
+		os.Exit(1)
+	}
+	return name
+}
+
+// Writes the Spinnaker account for the kubeconfig as YAML, a Halyard command and/or a SpinnakerService
+// patch, as asked for by flags
+// The account refers to the kubeconfig by its local path, or by Secret if --kubeconfig-secret is given
+func outputSpinnakerAccount(name string, sa k8s.ServiceAccount, kubeconfigFile string) {
+	account := spinnaker.Account{
+		Name:                 name,
+		KubeconfigFile:       kubeconfigFile,
+		Context:              k8s.KubeconfigContext(sa),
+		Namespaces:           sa.TargetNamespaces,
+		OnlySpinnakerManaged: onlySpinnakerManaged,
+	}
+	if kubeconfigSecret != "" {
+		account.KubeconfigFile = spinnaker.EncryptedFileReference(kubeconfigSecret, kubeconfigSecretKey)
+	}
+
+	if spinnakerAccountOutput != "" {
+		b, err := spinnaker.AccountsYAML(account)
+		if err == nil {
+			err = utils.WriteFileAtomic(spinnakerAccountOutput, b, 0644)
+		}
+		if err != nil {
+			color.Red("Writing Spinnaker account failed, exiting")
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		color.Green("Created Spinnaker account %s at %s", name, spinnakerAccountOutput)
+	}
+
+	if halCommand {
+		color.Green("Add the account to Spinnaker with:")
+		fmt.Println(spinnaker.HalCommand(account))
+	}
+
+	if operatorPatch != "" {
+		err := writeOperatorPatch(account, kubeconfigFile)
+		if err != nil {
+			color.Red("Writing SpinnakerService patch failed, exiting")
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		color.Green("Added Spinnaker account %s to SpinnakerService patch %s", name, operatorPatch)
+	}
+}
+
+// Merges the account into the SpinnakerService patch file (creating it if needed)
+// The kubeconfig is embedded in the patch, unless it is referenced by Secret
+func writeOperatorPatch(account spinnaker.Account, kubeconfigFile string) error {
+	existing, err := ioutil.ReadFile(operatorPatch)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var kubeconfig []byte
+	if kubeconfigSecret == "" {
+		kubeconfig, err = ioutil.ReadFile(kubeconfigFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	b, err := spinnaker.OperatorPatch(existing, account, kubeconfig)
+	if err != nil {
+		return err
+	}
+	// May hold the kubeconfig's credentials
+	return utils.WriteFileAtomic(operatorPatch, b, 0600)
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/armory/spinnaker-tools/internal/pkg/debug"
+	"github.com/armory/spinnaker-tools/internal/pkg/k8s"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var syncDryRun bool
+
+// syncServiceAccount updates the target namespaces of a service account created with a namespace selector
+var syncServiceAccount = &cobra.Command{
+	Use:   "sync",
+	Short: "Update the target namespaces of a Service Account created with --target-namespace-selector",
+	Long: `Given a Kubernetes service account created with --target-namespace-selector, will:
+	* grant it access to namespaces that match the selector but weren't there when it was created
+	* remove its RoleBindings (and Roles) from namespaces that no longer match
+The changes are shown, and confirmed before anything is changed (unless --yes is given).`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Create a debug context
+		ctx, err := debug.NewContext(true)
+		if err != nil {
+			fmt.Println("TODO: This needs error handling")
+		}
+
+		cluster := k8s.Cluster{
+			KubeconfigFile: sourceKubeconfig,
+			Context:        k8s.ClusterContext{ContextName: context},
+		}
+		serr, err := cluster.DefineCluster(ctx, verbose)
+		if err != nil || serr != "" {
+			color.Red("Defining cluster failed, exiting")
+			color.Red(serr)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+
+		sa := k8s.ServiceAccount{
+			Namespace:          namespace,
+			ServiceAccountName: serviceAccountName,
+		}
+		serr, err = cluster.SelectServiceAccount(ctx, &sa, verbose)
+		if err != nil || serr != "" {
+			color.Red("Selecting service account failed, exiting")
+			color.Red(serr)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+
+		sa.Provenance = cluster.NewProvenance(commandLine(), verbose)
+		diff, added, removed, serr, err := cluster.SyncServiceAccount(ctx, &sa, verbose)
+		if err != nil || serr != "" {
+			color.Red("Syncing service account failed, exiting")
+			color.Red(serr)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+
+		if !diff.Changed() {
+			color.Green("Service account %s is up to date with %s", sa.ServiceAccountName, sa.TargetNamespaceSelector)
+			return
+		}
+		color.Blue("Changes to context %s:", cluster.Context.ContextName)
+		for _, line := range diff.Summary() {
+			fmt.Println("  * " + line)
+		}
+		if syncDryRun {
+			color.Yellow("Dry run: nothing was changed")
+			return
+		}
+
+		serr, err = k8s.ConfirmChanges(assumeYes)
+		if err != nil {
+			color.Red(serr)
+			os.Exit(1)
+		}
+
+		serr, err = cluster.CreateServiceAccount(ctx, &sa, verbose)
+		if err != nil || serr != "" {
+			color.Red("Syncing service account failed, exiting")
+			color.Red(serr)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		if len(added) != 0 {
+			color.Green("Granted access to namespaces %s", strings.Join(added, ", "))
+		}
+		if len(removed) != 0 {
+			color.Green("Removed access to namespaces %s", strings.Join(removed, ", "))
+		}
+		color.Yellow("If the Spinnaker account lists its namespaces, update them to: %s", strings.Join(sa.TargetNamespaces, ", "))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(syncServiceAccount)
+
+	syncServiceAccount.PersistentFlags().StringVarP(&sourceKubeconfig, "kubeconfig", "i", "", "kubeconfig to start with")
+	syncServiceAccount.PersistentFlags().StringVarP(&context, "context", "c", "", "kubectl context to use")
+	syncServiceAccount.PersistentFlags().StringVarP(&namespace, "namespace", "n", "", "namespace of the service account")
+	syncServiceAccount.PersistentFlags().StringVarP(&serviceAccountName, "service-account-name", "s", "", "service account name")
+	syncServiceAccount.PersistentFlags().BoolVar(&syncDryRun, "dry-run", false, "only show what would change")
+	syncServiceAccount.PersistentFlags().BoolVarP(&assumeYes, "yes", "y", false, "don't ask for confirmation")
+	syncServiceAccount.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
+}
@@ -5,5 +5,8 @@ go 1.16
 require (
 	github.com/fatih/color v1.10.0
 	github.com/manifoldco/promptui v0.8.0
+	github.com/mattn/go-isatty v0.0.12
 	github.com/spf13/cobra v1.1.3
+	github.com/stretchr/testify v1.3.0
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,213 @@
+package accounts
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/armory/spinnaker-tools/internal/pkg/k8s"
+	"github.com/armory/spinnaker-tools/internal/pkg/spinnaker"
+)
+
+// File : A declarative list of Spinnaker Kubernetes accounts, and the service accounts backing them
+type File struct {
+	// If set, apply writes every account, as Spinnaker configuration, to this file
+	SpinnakerAccountOutput string  `yaml:"spinnakerAccountOutput"`
+	Accounts               []Entry `yaml:"accounts"`
+}
+
+// Entry : One account: the cluster, the service account to create in it, and where its kubeconfig goes
+// The fields match the flags of create-service-account
+type Entry struct {
+	// Spinnaker account name; derived from the cluster name if not set
+	Name string `yaml:"name"`
+
+	// Cluster: kubeconfig (defaults to ~/.kube/config) and context
+	Kubeconfig string `yaml:"kubeconfig"`
+	Context    string `yaml:"context"`
+
+	Namespace               string   `yaml:"namespace"`
+	ServiceAccountName      string   `yaml:"serviceAccountName"`
+	Role                    string   `yaml:"role"`
+	AllowSecrets            bool     `yaml:"allowSecrets"`
+	TargetNamespaces        []string `yaml:"targetNamespaces"`
+	TargetNamespaceSelector string   `yaml:"targetNamespaceSelector"`
+	NamespaceBinding        string   `yaml:"namespaceBinding"`
+	// prompt, fail (the default) or create
+	CreateMissingNamespaces string `yaml:"createMissingNamespaces"`
+
+	// token (the default) or certificate
+	CredentialType string        `yaml:"credentialType"`
+	TokenDuration  time.Duration `yaml:"tokenDuration"`
+	LongLivedToken bool          `yaml:"longLivedToken"`
+
+	// Where to write the kubeconfig
+	Output               string `yaml:"output"`
+	OnlySpinnakerManaged bool   `yaml:"onlySpinnakerManaged"`
+}
+
+// Defaults for fields of an Entry that aren't set
+const (
+	defaultTokenDuration = 8760 * time.Hour
+	tokenTimeout         = 2 * time.Minute
+	tokenPollInterval    = 2 * time.Second
+	certificateDuration  = 720 * time.Hour
+)
+
+// Load : Reads and validates an accounts file
+// Relative kubeconfig and output paths are resolved against the file's directory
+func Load(filename string) (File, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return File{}, err
+	}
+	f, err := parse(b)
+	if err != nil {
+		return File{}, errors.New(filename + ": " + err.Error())
+	}
+
+	dir := filepath.Dir(filename)
+	f.SpinnakerAccountOutput = resolve(dir, f.SpinnakerAccountOutput)
+	for i := range f.Accounts {
+		f.Accounts[i].Kubeconfig = resolve(dir, f.Accounts[i].Kubeconfig)
+		f.Accounts[i].Output = resolve(dir, f.Accounts[i].Output)
+	}
+	return f, nil
+}
+
+func parse(b []byte) (File, error) {
+	var f File
+	if err := yaml.UnmarshalStrict(b, &f); err != nil {
+		return File{}, err
+	}
+	if len(f.Accounts) == 0 {
+		return File{}, errors.New("no accounts")
+	}
+
+	outputs := map[string]bool{}
+	serviceAccounts := map[string]bool{}
+	for i, e := range f.Accounts {
+		if err := e.validate(); err != nil {
+			return File{}, fmt.Errorf("account %d (%s): %v", i+1, e.Context, err)
+		}
+		if outputs[e.Output] {
+			return File{}, fmt.Errorf("account %d (%s): output %s is used by another account", i+1, e.Context, e.Output)
+		}
+		outputs[e.Output] = true
+		key := e.Kubeconfig + "/" + e.Context + "/" + e.Namespace + "/" + e.ServiceAccountName
+		if serviceAccounts[key] {
+			return File{}, fmt.Errorf("account %d (%s): service account %s/%s is listed twice", i+1, e.Context, e.Namespace, e.ServiceAccountName)
+		}
+		serviceAccounts[key] = true
+	}
+	return f, nil
+}
+
+// Relative paths are relative to dir, and ~/ to the home directory
+func resolve(dir string, path string) string {
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(os.Getenv("HOME"), path[2:])
+	}
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// Checks everything create-service-account would otherwise prompt for is given
+func (e Entry) validate() error {
+	required := []struct{ field, value string }{
+		{"context", e.Context},
+		{"namespace", e.Namespace},
+		{"serviceAccountName", e.ServiceAccountName},
+		{"output", e.Output},
+	}
+	for _, r := range required {
+		if r.value == "" {
+			return errors.New(r.field + " is required")
+		}
+	}
+	if len(e.TargetNamespaces) != 0 && e.TargetNamespaceSelector != "" {
+		return errors.New("targetNamespaces cannot be combined with targetNamespaceSelector")
+	}
+	if e.Name != "" {
+		if err := spinnaker.ValidateAccountName(e.Name); err != nil {
+			return err
+		}
+	}
+
+	sa, err := e.ServiceAccount()
+	if err != nil {
+		return err
+	}
+	if sa.Role == "" && !(sa.Namespaced() && sa.NamespaceBinding.BuiltIn()) {
+		return errors.New("role is required")
+	}
+	if sa.Role != "" && sa.Namespaced() && sa.NamespaceBinding.BuiltIn() {
+		return errors.New("namespaceBinding " + string(sa.NamespaceBinding) + " grants the built-in ClusterRole, and cannot be combined with role")
+	}
+	return nil
+}
+
+// Cluster : The cluster to create the service account in
+func (e Entry) Cluster() k8s.Cluster {
+	return k8s.Cluster{
+		KubeconfigFile: e.Kubeconfig,
+		Context:        k8s.ClusterContext{ContextName: e.Context},
+	}
+}
+
+// ServiceAccount : The service account to create, ready for DefineServiceAccount
+func (e Entry) ServiceAccount() (k8s.ServiceAccount, error) {
+	sa := k8s.ServiceAccount{
+		Namespace:               e.Namespace,
+		ServiceAccountName:      e.ServiceAccountName,
+		AllowSecrets:            e.AllowSecrets,
+		TargetNamespaces:        e.TargetNamespaces,
+		TargetNamespaceSelector: e.TargetNamespaceSelector,
+		Token: k8s.TokenOptions{
+			Duration:     e.TokenDuration,
+			CreateSecret: e.LongLivedToken,
+			Timeout:      tokenTimeout,
+			PollInterval: tokenPollInterval,
+		},
+		Certificate: k8s.CertificateOptions{
+			Duration:     certificateDuration,
+			Timeout:      tokenTimeout,
+			PollInterval: tokenPollInterval,
+		},
+	}
+	if sa.Token.Duration == 0 {
+		sa.Token.Duration = defaultTokenDuration
+	}
+	if sa.Token.CreateSecret {
+		sa.Token.SecretName = sa.ServiceAccountName + "-token"
+	}
+
+	var err error
+	if sa.Role, err = k8s.ParseRole(e.Role); err != nil {
+		return sa, err
+	}
+	if sa.NamespaceBinding, err = k8s.ParseNamespaceBinding(e.NamespaceBinding); err != nil {
+		return sa, err
+	}
+	if sa.Namespaced() && sa.NamespaceBinding == "" {
+		sa.NamespaceBinding = k8s.RolePerNamespace
+	}
+	// Unlike on the command line, missing namespaces fail unless the file says otherwise
+	if e.CreateMissingNamespaces == "" {
+		sa.MissingNamespaces = k8s.FailMissingNamespaces
+	} else if sa.MissingNamespaces, err = k8s.ParseMissingNamespacePolicy(e.CreateMissingNamespaces); err != nil {
+		return sa, err
+	}
+	if sa.Credentials, err = k8s.ParseCredentialType(e.CredentialType); err != nil {
+		return sa, err
+	}
+	return sa, nil
+}
@@ -0,0 +1,90 @@
+package accounts
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/armory/spinnaker-tools/internal/pkg/k8s"
+)
+
+const testFile = `
+spinnakerAccountOutput: accounts.yml
+accounts:
+- context: prod
+  namespace: spinnaker
+  serviceAccountName: deployer
+  role: spinnaker-deployer
+  targetNamespaces: [apps, jobs]
+  output: kubeconfigs/prod
+- name: staging
+  kubeconfig: /etc/kube/staging
+  context: staging
+  namespace: spinnaker
+  serviceAccountName: viewer
+  targetNamespaceSelector: team=payments
+  namespaceBinding: view
+  createMissingNamespaces: create
+  credentialType: certificate
+  output: /tmp/staging
+`
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accounts")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "accounts.yaml")
+	assert.Nil(t, ioutil.WriteFile(filename, []byte(testFile), 0644))
+
+	f, err := Load(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, "accounts.yml"), f.SpinnakerAccountOutput)
+	assert.Equal(t, filepath.Join(dir, "kubeconfigs/prod"), f.Accounts[0].Output)
+	assert.Equal(t, "", f.Accounts[0].Kubeconfig)
+	assert.Equal(t, "/etc/kube/staging", f.Accounts[1].Kubeconfig)
+	assert.Equal(t, "/tmp/staging", f.Accounts[1].Output)
+}
+
+func TestServiceAccount(t *testing.T) {
+	f, err := parse([]byte(testFile))
+	assert.Nil(t, err)
+
+	sa, err := f.Accounts[0].ServiceAccount()
+	assert.Nil(t, err)
+	assert.Equal(t, k8s.SpinnakerDeployerRole, sa.Role)
+	assert.Equal(t, k8s.RolePerNamespace, sa.NamespaceBinding)
+	assert.Equal(t, k8s.FailMissingNamespaces, sa.MissingNamespaces)
+	assert.Equal(t, k8s.TokenCredentials, sa.Credentials)
+	assert.Equal(t, defaultTokenDuration, sa.Token.Duration)
+
+	sa, err = f.Accounts[1].ServiceAccount()
+	assert.Nil(t, err)
+	assert.Equal(t, k8s.Role(""), sa.Role)
+	assert.Equal(t, k8s.ViewBinding, sa.NamespaceBinding)
+	assert.Equal(t, k8s.CreateMissingNamespaces, sa.MissingNamespaces)
+	assert.Equal(t, k8s.CertificateCredentials, sa.Credentials)
+	assert.True(t, sa.ReadOnly())
+}
+
+func TestParseInvalid(t *testing.T) {
+	for name, file := range map[string]string{
+		"no accounts":    "accounts: []\n",
+		"unknown field":  "accounts:\n- context: a\n  namespace: s\n  serviceAccountName: sa\n  role: read-only\n  output: a\n  rol: x\n",
+		"missing output": "accounts:\n- context: a\n  namespace: s\n  serviceAccountName: sa\n  role: read-only\n",
+		"missing role":   "accounts:\n- context: a\n  namespace: s\n  serviceAccountName: sa\n  output: a\n",
+		"role and built-in binding": "accounts:\n- context: a\n  namespace: s\n  serviceAccountName: sa\n  role: read-only\n" +
+			"  targetNamespaces: [x]\n  namespaceBinding: edit\n  output: a\n",
+		"targets and selector": "accounts:\n- context: a\n  namespace: s\n  serviceAccountName: sa\n  role: read-only\n" +
+			"  targetNamespaces: [x]\n  targetNamespaceSelector: team=x\n  output: a\n",
+		"duplicate output": "accounts:\n- context: a\n  namespace: s\n  serviceAccountName: sa\n  role: read-only\n  output: a\n" +
+			"- context: b\n  namespace: s\n  serviceAccountName: sa\n  role: read-only\n  output: a\n",
+		"duplicate service account": "accounts:\n- context: a\n  namespace: s\n  serviceAccountName: sa\n  role: read-only\n  output: a\n" +
+			"- context: a\n  namespace: s\n  serviceAccountName: sa\n  role: read-only\n  output: b\n",
+	} {
+		_, err := parse([]byte(file))
+		assert.NotNil(t, err, name)
+	}
+}
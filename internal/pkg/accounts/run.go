@@ -0,0 +1,186 @@
+package accounts
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/armory/spinnaker-tools/internal/pkg/diagnostics"
+	"github.com/armory/spinnaker-tools/internal/pkg/k8s"
+	"github.com/armory/spinnaker-tools/internal/pkg/spinnaker"
+	"github.com/armory/spinnaker-tools/internal/pkg/utils"
+	"github.com/fatih/color"
+)
+
+// Result : What plan or apply did for one account
+type Result struct {
+	Entry   Entry
+	Cluster k8s.Cluster
+	// How the objects in the cluster change (see k8s.ServiceAccountDiff)
+	Changes []string
+	// Set by Apply
+	Account spinnaker.Account
+	// If it failed: error string, error
+	Message string
+	Err     error
+}
+
+// Plan : Runs DefineCluster and DefineServiceAccount for every account, and describes what apply would
+// create or update, without changing anything
+func Plan(ctx diagnostics.Handler, f File, verbose bool) []Result {
+	var results []Result
+	for _, e := range f.Accounts {
+		r := Result{Entry: e}
+		var sa k8s.ServiceAccount
+		r.Cluster, sa, r.Message, r.Err = e.define(ctx, verbose)
+		if r.Err == nil {
+			_, r.Changes, r.Message, r.Err = diffAccount(r.Cluster, &sa, verbose)
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// Apply : Creates the service account and kubeconfig of every account, as create-service-account would
+// An account that fails doesn't stop the others; the Spinnaker configuration only lists those that succeeded
+// command is recorded on the objects created (see k8s.Provenance)
+// Changes to existing objects, such as deleting bindings the accounts file no longer calls for, are
+// confirmed for each account, unless assumeYes; without a terminal to confirm on, those accounts fail
+func Apply(ctx diagnostics.Handler, f File, command []string, assumeYes bool, verbose bool) []Result {
+	var results []Result
+	var created []spinnaker.Account
+	for _, e := range f.Accounts {
+		r := e.apply(ctx, command, assumeYes, verbose)
+		if r.Err == nil {
+			created = append(created, r.Account)
+		}
+		results = append(results, r)
+	}
+
+	if f.SpinnakerAccountOutput != "" && len(created) != 0 {
+		b, err := spinnaker.AccountsYAML(created...)
+		if err == nil {
+			err = utils.WriteFileAtomic(f.SpinnakerAccountOutput, b, 0644)
+		}
+		if err != nil {
+			results = append(results, Result{Message: "Unable to write Spinnaker accounts to " + f.SpinnakerAccountOutput, Err: err})
+		}
+	}
+	return results
+}
+
+// Runs DefineCluster and DefineServiceAccount for the account
+// Returns cluster, service account, error string, error
+func (e Entry) define(ctx diagnostics.Handler, verbose bool) (k8s.Cluster, k8s.ServiceAccount, string, error) {
+	cluster := e.Cluster()
+	serr, err := cluster.DefineCluster(ctx, verbose)
+	if err != nil || serr != "" {
+		return cluster, k8s.ServiceAccount{}, "Defining cluster failed: " + serr, errOrUnknown(err)
+	}
+
+	sa, err := e.ServiceAccount()
+	if err != nil {
+		return cluster, sa, "Invalid account", err
+	}
+	serr, err = cluster.DefineServiceAccount(ctx, &sa, verbose)
+	if err != nil || serr != "" {
+		return cluster, sa, "Defining service account failed: " + serr, errOrUnknown(err)
+	}
+	return cluster, sa, "", nil
+}
+
+// Compares the account's service account with what is in the cluster; apply then only changes what differs
+// Returns diff, changes, error string, error
+func diffAccount(cluster k8s.Cluster, sa *k8s.ServiceAccount, verbose bool) (k8s.ServiceAccountDiff, []string, string, error) {
+	diff, serr, err := cluster.DiffServiceAccount(sa, verbose)
+	if err != nil {
+		return diff, nil, "Comparing with the cluster failed: " + serr, err
+	}
+	if !diff.Changed() {
+		return diff, []string{"no changes"}, "", nil
+	}
+	return diff, diff.Summary(), "", nil
+}
+
+// Creates the service account and its kubeconfig, the same steps as create-service-account, including
+// confirming changes to existing objects (see Apply)
+func (e Entry) apply(ctx diagnostics.Handler, command []string, assumeYes bool, verbose bool) Result {
+	r := Result{Entry: e}
+	var sa k8s.ServiceAccount
+	r.Cluster, sa, r.Message, r.Err = e.define(ctx, verbose)
+	if r.Err != nil {
+		return r
+	}
+	var diff k8s.ServiceAccountDiff
+	diff, r.Changes, r.Message, r.Err = diffAccount(r.Cluster, &sa, verbose)
+	if r.Err != nil {
+		return r
+	}
+	if diff.NeedsConfirmation() {
+		if !assumeYes && utils.IsInteractive() {
+			color.Blue("Changes to context %s:", r.Cluster.Context.ContextName)
+			for _, line := range r.Changes {
+				fmt.Println("  * " + line)
+			}
+		}
+		if serr, err := k8s.ConfirmChanges(assumeYes); err != nil {
+			r.Message, r.Err = serr, err
+			return r
+		}
+	}
+	sa.Provenance = r.Cluster.NewProvenance(command, verbose)
+
+	name := e.Name
+	if name == "" {
+		name = spinnaker.AccountName(r.Cluster.Context.ClusterName)
+		if sa.ReadOnly() {
+			name += "-readonly"
+		}
+		if err := spinnaker.ValidateAccountName(name); err != nil {
+			r.Message, r.Err = "Set the account's name", err
+			return r
+		}
+	}
+
+	f, serr, err := r.Cluster.DefineKubeconfig(e.Output, &sa, verbose)
+	if err != nil || serr != "" {
+		r.Message, r.Err = "Defining kubeconfig failed: "+serr, errOrUnknown(err)
+		return r
+	}
+
+	serr, err = r.Cluster.CreateServiceAccount(ctx, &sa, verbose)
+	if err != nil || serr != "" {
+		r.Message, r.Err = "Creating service account failed: "+serr, errOrUnknown(err)
+		return r
+	}
+
+	if sa.Credentials == k8s.TokenCredentials {
+		serr, err = r.Cluster.WaitForToken(ctx, sa, verbose)
+		if err != nil || serr != "" {
+			r.Message, r.Err = "Waiting for service account token failed: "+serr, errOrUnknown(err)
+			return r
+		}
+	}
+
+	o, serr, err := r.Cluster.CreateKubeconfig(ctx, f, sa, verbose)
+	if err != nil || serr != "" {
+		r.Message, r.Err = "Creating kubeconfig failed: "+serr, errOrUnknown(err)
+		return r
+	}
+
+	r.Account = spinnaker.Account{
+		Name:                 name,
+		KubeconfigFile:       o,
+		Context:              k8s.KubeconfigContext(sa),
+		Namespaces:           sa.TargetNamespaces,
+		OnlySpinnakerManaged: e.OnlySpinnakerManaged,
+	}
+	return r
+}
+
+// Some Define* steps report failures only through the error string
+func errOrUnknown(err error) error {
+	if err == nil {
+		return errors.New("failed")
+	}
+	return err
+}
@@ -0,0 +1,233 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Where Kubernetes mounts the credentials of the pod's service account
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Name the controller applies objects as (server-side apply field manager)
+const fieldManager = "spinnaker-tools-controller"
+
+// How long a single (non-watch) request may take
+const requestTimeout = 30 * time.Second
+
+// How long the API server keeps a watch open before the controller starts a new one
+const watchTimeoutSeconds = 300
+
+// Client : A minimal client for the Kubernetes REST API, covering what the controller needs
+type Client struct {
+	// Base URL of the API server, e.g. https://10.0.0.1:443
+	Server string
+	HTTP   *http.Client
+	// File holding the bearer token; read on every request, since projected tokens are rotated
+	// Empty for no authentication (e.g. through `kubectl proxy`)
+	TokenFile string
+}
+
+// NewClient : A client for an API server that needs no authentication, such as `kubectl proxy`
+func NewClient(server string) *Client {
+	return &Client{Server: strings.TrimSuffix(server, "/"), HTTP: &http.Client{}}
+}
+
+// NewInClusterClient : A client for the API server of the cluster the controller runs in, authenticated
+// as the pod's service account
+func NewInClusterClient() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a Kubernetes cluster (KUBERNETES_SERVICE_HOST is not set)")
+	}
+
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no certificates in " + serviceAccountDir + "/ca.crt")
+	}
+
+	return &Client{
+		Server: "https://" + net.JoinHostPort(host, port),
+		HTTP: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+		TokenFile: serviceAccountDir + "/token",
+	}, nil
+}
+
+// InClusterNamespace : The namespace the controller's pod runs in
+func InClusterNamespace() (string, error) {
+	b, err := ioutil.ReadFile(serviceAccountDir + "/namespace")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// APIError : An error response from the API server
+type APIError struct {
+	Code    int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d: %s", e.Code, e.Message)
+}
+
+// IsNotFound : Whether err is a 404 from the API server
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+// Sends a request, and returns the response if it was successful
+// The caller must close the response body
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, contentType string, body []byte) (*http.Response, error) {
+	u := c.Server + path
+	if len(query) != 0 {
+		u += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.TokenFile != "" {
+		token, err := ioutil.ReadFile(c.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	apiErr := &APIError{Code: resp.StatusCode}
+	var status struct {
+		Message string `json:"message"`
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	if json.Unmarshal(b, &status) == nil && status.Message != "" {
+		apiErr.Message = status.Message
+	} else {
+		apiErr.Message = strings.TrimSpace(string(b))
+	}
+	return nil, apiErr
+}
+
+// Gets the object or list at path, decoding it into out
+func (c *Client) get(ctx context.Context, path string, query url.Values, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	resp, err := c.do(ctx, http.MethodGet, path, query, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Creates or updates an object through server-side apply
+// Objects whose applied fields already match are left untouched by the API server
+func (c *Client) apply(ctx context.Context, obj object) error {
+	path, err := obj.path()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	query := url.Values{"fieldManager": {fieldManager}, "force": {"true"}}
+	resp, err := c.do(ctx, http.MethodPatch, path, query, "application/apply-patch+yaml", body)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Deletes the object at path; objects that are already gone are not an error
+func (c *Client) delete(ctx context.Context, path string) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	resp, err := c.do(ctx, http.MethodDelete, path, nil, "", nil)
+	if IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Watches the collection at path, calling onEvent for every event, until ctx is done or the API
+// server ends the watch
+func (c *Client) watch(ctx context.Context, path string, query url.Values, onEvent func()) error {
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set("watch", "true")
+	q.Set("timeoutSeconds", fmt.Sprint(watchTimeoutSeconds))
+
+	resp, err := c.do(ctx, http.MethodGet, path, q, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event struct {
+			Type string `json:"type"`
+		}
+		if err := decoder.Decode(&event); err != nil {
+			if err == io.EOF || ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if event.Type == "ERROR" {
+			// Usually "too old resource version"; a new watch starts over
+			return errors.New("watch of " + path + " failed")
+		}
+		onEvent()
+	}
+}
@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/armory/spinnaker-tools/internal/pkg/k8s"
+)
+
+// How long to wait before restarting a watch that failed
+const watchRetryInterval = 5 * time.Second
+
+// Options : How the controller runs
+type Options struct {
+	// Address the health and metrics endpoints listen on, e.g. :8080
+	ListenAddress string
+	// How often to reconcile even if nothing was seen to change, to undo drift in objects that aren't watched
+	ResyncPeriod time.Duration
+}
+
+// Run : Reconciles whenever Namespaces, tool-managed ServiceAccounts or the spec ConfigMap change, and
+// every ResyncPeriod, until ctx is done
+// Serves /healthz, /readyz (once a reconciliation has succeeded) and /metrics on ListenAddress
+func Run(ctx context.Context, r *Reconciler, opts Options) error {
+	if r.Metrics == nil {
+		r.Metrics = &Metrics{}
+	}
+
+	server := &http.Server{Addr: opts.ListenAddress, Handler: handler(r.Metrics)}
+	serverErrors := make(chan error, 1)
+	go func() {
+		serverErrors <- server.ListenAndServe()
+	}()
+	defer server.Close()
+
+	// Buffered, so changes seen during a reconciliation lead to exactly one more
+	trigger := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}
+
+	namespacesPath, _ := resourcePath("v1", "Namespace", "", "")
+	serviceAccountsPath, _ := resourcePath("v1", "ServiceAccount", "", "")
+	configMapsPath, _ := resourcePath("v1", "ConfigMap", r.Namespace, "")
+	go watchForever(ctx, r.Client, namespacesPath, nil, notify)
+	go watchForever(ctx, r.Client, serviceAccountsPath, url.Values{"labelSelector": {k8s.ManagedByLabel + "=" + k8s.ManagedByValue}}, notify)
+	go watchForever(ctx, r.Client, configMapsPath, url.Values{"fieldSelector": {"metadata.name=" + r.ConfigMap}}, notify)
+
+	ticker := time.NewTicker(opts.ResyncPeriod)
+	defer ticker.Stop()
+
+	notify()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-serverErrors:
+			return err
+		case <-ticker.C:
+		case <-trigger:
+		}
+
+		if err := r.Reconcile(ctx); err != nil {
+			log.Printf("Reconciliation failed: %v", err)
+		} else {
+			log.Printf("Reconciled")
+		}
+	}
+}
+
+// Watches path, restarting the watch whenever it ends, until ctx is done
+func watchForever(ctx context.Context, client *Client, path string, query url.Values, onEvent func()) {
+	for ctx.Err() == nil {
+		if err := client.watch(ctx, path, query, onEvent); err != nil {
+			log.Printf("Watching %s failed: %v", path, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(watchRetryInterval):
+			}
+		}
+	}
+}
+
+// Health and metrics endpoints
+func handler(metrics *Metrics) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) {
+		if !metrics.ready() {
+			http.Error(w, "not reconciled yet", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+	mux.Handle("/metrics", metrics)
+	return mux
+}
@@ -0,0 +1,365 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/armory/spinnaker-tools/internal/pkg/k8s"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAPIServer : Just enough of the Kubernetes API for the controller: server-side apply (refusing to
+// change the roleRef of bindings, as the API server does, and keeping annotations set by other field
+// managers, such as adopt's), get, list (with equality label and field
+// selectors), and delete
+type fakeAPIServer struct {
+	mutex   sync.Mutex
+	objects map[string]map[string]interface{}
+}
+
+func newFakeAPIServer() *fakeAPIServer {
+	return &fakeAPIServer{objects: map[string]map[string]interface{}{}}
+}
+
+func (f *fakeAPIServer) add(path string, obj map[string]interface{}) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.objects[path] = obj
+}
+
+func (f *fakeAPIServer) has(path string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	_, ok := f.objects[path]
+	return ok
+}
+
+func (f *fakeAPIServer) paths(prefix string) []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var paths []string
+	for path := range f.objects {
+		if strings.HasPrefix(path, prefix) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+func (f *fakeAPIServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	path := req.URL.Path
+	switch req.Method {
+	case http.MethodPatch:
+		if req.Header.Get("Content-Type") != "application/apply-patch+yaml" || req.URL.Query().Get("fieldManager") == "" {
+			http.Error(w, `{"message":"not a server-side apply"}`, http.StatusBadRequest)
+			return
+		}
+		var obj map[string]interface{}
+		b, _ := ioutil.ReadAll(req.Body)
+		if err := json.Unmarshal(b, &obj); err != nil {
+			http.Error(w, `{"message":"invalid body"}`, http.StatusBadRequest)
+			return
+		}
+		if existing, ok := f.objects[path]; ok && existing["roleRef"] != nil && fmt.Sprint(existing["roleRef"]) != fmt.Sprint(obj["roleRef"]) {
+			http.Error(w, `{"message":"cannot change roleRef"}`, http.StatusUnprocessableEntity)
+			return
+		}
+		if existing, ok := f.objects[path]; ok {
+			keepAnnotations(existing, obj)
+		}
+		f.objects[path] = obj
+		json.NewEncoder(w).Encode(obj)
+	case http.MethodDelete:
+		if _, ok := f.objects[path]; !ok {
+			http.Error(w, `{"message":"not found"}`, http.StatusNotFound)
+			return
+		}
+		delete(f.objects, path)
+		w.Write([]byte(`{}`))
+	case http.MethodGet:
+		if obj, ok := f.objects[path]; ok {
+			json.NewEncoder(w).Encode(obj)
+			return
+		}
+		plural := path[strings.LastIndex(path, "/")+1:]
+		if _, ok := resourceNames()[plural]; !ok {
+			http.Error(w, `{"message":"not found"}`, http.StatusNotFound)
+			return
+		}
+		items := []interface{}{}
+		for objPath, obj := range f.objects {
+			collection := objPath[:strings.LastIndex(objPath, "/")]
+			if collection != path && allNamespaces(collection) != path {
+				continue
+			}
+			if matches(obj, req.URL.Query().Get("labelSelector"), req.URL.Query().Get("fieldSelector")) {
+				items = append(items, obj)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+	}
+}
+
+// Copies the annotations of existing that obj doesn't set
+func keepAnnotations(existing map[string]interface{}, obj map[string]interface{}) {
+	from, _ := existing["metadata"].(map[string]interface{})
+	old, _ := from["annotations"].(map[string]interface{})
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	if len(old) == 0 || metadata == nil {
+		return
+	}
+	annotations, _ := metadata["annotations"].(map[string]interface{})
+	if annotations == nil {
+		annotations = map[string]interface{}{}
+		metadata["annotations"] = annotations
+	}
+	for key, value := range old {
+		if _, ok := annotations[key]; !ok {
+			annotations[key] = value
+		}
+	}
+}
+
+func resourceNames() map[string]bool {
+	names := map[string]bool{}
+	for _, resource := range resources {
+		names[resource.name] = true
+	}
+	return names
+}
+
+// /api/v1/namespaces/ns/roles -> /api/v1/roles
+func allNamespaces(collection string) string {
+	parts := strings.Split(collection, "/")
+	for i := range parts {
+		if parts[i] == "namespaces" && i+2 < len(parts) {
+			return strings.Join(append(append([]string{}, parts[:i]...), parts[i+2:]...), "/")
+		}
+	}
+	return collection
+}
+
+func matches(obj map[string]interface{}, labelSelector string, fieldSelector string) bool {
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	labels, _ := metadata["labels"].(map[string]interface{})
+	for _, term := range strings.Split(labelSelector, ",") {
+		kv := strings.SplitN(term, "=", 2)
+		if term != "" && labels[kv[0]] != kv[1] {
+			return false
+		}
+	}
+	if fieldSelector != "" {
+		kv := strings.SplitN(fieldSelector, "=", 2)
+		if kv[0] != "metadata.name" || metadata["name"] != kv[1] {
+			return false
+		}
+	}
+	return true
+}
+
+func namespace(name string, labels map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{"name": name, "labels": labels},
+		"status":   map[string]interface{}{"phase": "Active"},
+	}
+}
+
+func specConfigMap(spec string) map[string]interface{} {
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "spinnaker-tools", "namespace": "spinnaker"},
+		"data":     map[string]interface{}{SpecKey: spec},
+	}
+}
+
+func newTestReconciler(t *testing.T, api *fakeAPIServer) *Reconciler {
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	return &Reconciler{Client: NewClient(server.URL), Namespace: "spinnaker", ConfigMap: "spinnaker-tools", Metrics: &Metrics{}}
+}
+
+func TestReconcileSelector(t *testing.T) {
+	api := newFakeAPIServer()
+	api.add("/api/v1/namespaces/spinnaker", namespace("spinnaker", nil))
+	api.add("/api/v1/namespaces/pay-1", namespace("pay-1", map[string]interface{}{"team": "payments"}))
+	api.add("/api/v1/namespaces/pay-2", namespace("pay-2", map[string]interface{}{"team": "payments"}))
+	api.add("/api/v1/namespaces/other", namespace("other", nil))
+	api.add("/api/v1/namespaces/spinnaker/configmaps/spinnaker-tools", specConfigMap(`
+serviceAccounts:
+- namespace: spinnaker
+  name: payments
+  role: spinnaker-deployer
+  targetNamespaceSelector: team=payments
+`))
+	r := newTestReconciler(t, api)
+
+	assert.Nil(t, r.Reconcile(context.Background()))
+	assert.True(t, api.has("/api/v1/namespaces/spinnaker/serviceaccounts/payments"))
+	for _, ns := range []string{"pay-1", "pay-2"} {
+		assert.True(t, api.has("/apis/rbac.authorization.k8s.io/v1/namespaces/"+ns+"/rolebindings/spinnaker-payments-binding"))
+		assert.True(t, api.has("/apis/rbac.authorization.k8s.io/v1/namespaces/"+ns+"/roles/spinnaker-payments-local-admin"))
+	}
+	assert.Empty(t, api.paths("/apis/rbac.authorization.k8s.io/v1/namespaces/other/"))
+	assert.Empty(t, api.paths("/apis/rbac.authorization.k8s.io/v1/clusterrolebindings/"))
+
+	// A namespace that stops matching loses its binding and role
+	api.add("/api/v1/namespaces/pay-2", namespace("pay-2", nil))
+	assert.Nil(t, r.Reconcile(context.Background()))
+	assert.True(t, api.has("/apis/rbac.authorization.k8s.io/v1/namespaces/pay-1/rolebindings/spinnaker-payments-binding"))
+	assert.Empty(t, api.paths("/apis/rbac.authorization.k8s.io/v1/namespaces/pay-2/"))
+	assert.Equal(t, 2, r.Metrics.objectsDeleted)
+}
+
+func TestReconcileRoleChange(t *testing.T) {
+	api := newFakeAPIServer()
+	api.add("/api/v1/namespaces/spinnaker", namespace("spinnaker", nil))
+	api.add("/api/v1/namespaces/apps", namespace("apps", nil))
+	setSpec := func(entry string) {
+		api.add("/api/v1/namespaces/spinnaker/configmaps/spinnaker-tools", specConfigMap("serviceAccounts:\n- namespace: spinnaker\n  name: deployer\n"+entry))
+	}
+	r := newTestReconciler(t, api)
+	rbac := "/apis/rbac.authorization.k8s.io/v1/"
+
+	setSpec("")
+	assert.Nil(t, r.Reconcile(context.Background()))
+	assert.True(t, api.has(rbac+"clusterrolebindings/spinnaker-deployer-admin"))
+
+	// cluster-admin is taken away when the role preset changes
+	setSpec("  role: spinnaker-deployer\n")
+	assert.Nil(t, r.Reconcile(context.Background()))
+	assert.False(t, api.has(rbac+"clusterrolebindings/spinnaker-deployer-admin"))
+	assert.True(t, api.has(rbac+"clusterrolebindings/spinnaker-deployer-spinnaker-deployer"))
+	assert.True(t, api.has(rbac+"clusterroles/spinnaker-deployer-spinnaker-deployer"))
+
+	// and cluster-wide access when it is limited to target namespaces
+	setSpec("  role: spinnaker-deployer\n  targetNamespaces: [apps]\n")
+	assert.Nil(t, r.Reconcile(context.Background()))
+	assert.Empty(t, api.paths(rbac+"clusterrolebindings/"))
+	assert.Empty(t, api.paths(rbac+"clusterroles/"))
+	assert.True(t, api.has(rbac+"namespaces/apps/rolebindings/spinnaker-deployer-binding"))
+	assert.True(t, api.has(rbac+"namespaces/apps/roles/spinnaker-deployer-local-admin"))
+
+	// A binding to another role is replaced, as its roleRef can't be changed
+	setSpec("  targetNamespaces: [apps]\n  namespaceBinding: edit\n")
+	assert.Nil(t, r.Reconcile(context.Background()))
+	var binding object
+	assert.Nil(t, r.Client.get(context.Background(), rbac+"namespaces/apps/rolebindings/spinnaker-deployer-binding", nil, &binding))
+	kind, name := binding.roleRef()
+	assert.Equal(t, "ClusterRole edit", kind+" "+name)
+	assert.Empty(t, api.paths(rbac+"namespaces/apps/roles/"))
+
+	// Nothing changes once it's in line
+	deleted := r.Metrics.objectsDeleted
+	assert.Nil(t, r.Reconcile(context.Background()))
+	assert.Equal(t, deleted, r.Metrics.objectsDeleted)
+}
+
+func TestReconcileAdopted(t *testing.T) {
+	api := newFakeAPIServer()
+	api.add("/api/v1/namespaces/spinnaker", namespace("spinnaker", nil))
+	api.add("/api/v1/namespaces/apps", namespace("apps", nil))
+	api.add("/api/v1/namespaces/spinnaker/configmaps/spinnaker-tools", specConfigMap(`
+serviceAccounts:
+- namespace: spinnaker
+  name: ci
+  targetNamespaces: [apps]
+`))
+	// Adopted, so labelled for the service account and recorded on it
+	labels := map[string]interface{}{
+		"app.kubernetes.io/managed-by":                        "spinnaker-tools",
+		"spinnaker-tools.armory.io/service-account-namespace": "spinnaker",
+		"spinnaker-tools.armory.io/service-account-name":      "ci",
+	}
+	api.add("/api/v1/namespaces/spinnaker/serviceaccounts/ci", map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name": "ci", "namespace": "spinnaker", "labels": labels,
+			"annotations": map[string]interface{}{k8s.AdoptedBindingsAnnotation: "ClusterRoleBinding legacy-crb"},
+		},
+	})
+	crb := "/apis/rbac.authorization.k8s.io/v1/clusterrolebindings/legacy-crb"
+	api.add(crb, map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "legacy-crb", "labels": labels},
+		"roleRef":  map[string]interface{}{"apiGroup": "rbac.authorization.k8s.io", "kind": "ClusterRole", "name": "cluster-admin"},
+		"subjects": []interface{}{map[string]interface{}{"kind": "ServiceAccount", "name": "ci", "namespace": "spinnaker"}},
+	})
+	r := newTestReconciler(t, api)
+
+	assert.Nil(t, r.Reconcile(context.Background()))
+	assert.True(t, api.has("/apis/rbac.authorization.k8s.io/v1/namespaces/apps/rolebindings/spinnaker-ci-binding"))
+	assert.True(t, api.has(crb))
+	assert.Equal(t, 0, r.Metrics.objectsDeleted)
+
+	// Resyncs leave it alone too
+	assert.Nil(t, r.Reconcile(context.Background()))
+	assert.True(t, api.has(crb))
+}
+
+func TestReconcileMissingTargetNamespaces(t *testing.T) {
+	api := newFakeAPIServer()
+	api.add("/api/v1/namespaces/spinnaker", namespace("spinnaker", nil))
+	api.add("/api/v1/namespaces/spinnaker/configmaps/spinnaker-tools", specConfigMap(`
+serviceAccounts:
+- namespace: spinnaker
+  name: typo
+  targetNamespaces: [aps]
+- namespace: spinnaker
+  name: admin
+`))
+	r := newTestReconciler(t, api)
+
+	err := r.Reconcile(context.Background())
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "spinnaker/typo")
+
+	// Neither the missing namespace nor cluster-wide access is created for it
+	assert.False(t, api.has("/api/v1/namespaces/aps"))
+	assert.False(t, api.has("/apis/rbac.authorization.k8s.io/v1/clusterrolebindings/spinnaker-typo-admin"))
+
+	// The other service account is still reconciled
+	assert.True(t, api.has("/apis/rbac.authorization.k8s.io/v1/clusterrolebindings/spinnaker-admin-admin"))
+	assert.Equal(t, 1, r.Metrics.reconcileErrors)
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	api := newFakeAPIServer()
+	api.add("/api/v1/namespaces/spinnaker", namespace("spinnaker", nil))
+	api.add("/api/v1/namespaces/spinnaker/configmaps/spinnaker-tools", specConfigMap("serviceAccounts: []\n"))
+	r := newTestReconciler(t, api)
+	h := handler(r.Metrics)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	assert.Nil(t, r.Reconcile(context.Background()))
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), "spinnaker_tools_reconcile_total 1\n")
+}
+
+func TestParseSpec(t *testing.T) {
+	_, err := ParseSpec([]byte("serviceAccounts:\n- namespace: spinnaker\n  name: a\n  role: root\n"))
+	assert.NotNil(t, err)
+	_, err = ParseSpec([]byte("serviceAccounts:\n- namespace: spinnaker\n  name: a\n  rol: read-only\n"))
+	assert.NotNil(t, err)
+	_, err = ParseSpec([]byte("serviceAccounts:\n- name: a\n"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Metrics : Counters about reconciliation, served in the Prometheus text format
+type Metrics struct {
+	mutex           sync.Mutex
+	reconciles      int
+	reconcileErrors int
+	objectsApplied  int
+	objectsDeleted  int
+	serviceAccounts int
+	lastDuration    time.Duration
+	lastSuccess     time.Time
+	lastError       error
+}
+
+func (m *Metrics) reconciled(start time.Time, serviceAccounts int, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.reconciles++
+	m.lastDuration = time.Since(start)
+	m.lastError = err
+	if err != nil {
+		m.reconcileErrors++
+		return
+	}
+	m.serviceAccounts = serviceAccounts
+	m.lastSuccess = time.Now()
+}
+
+func (m *Metrics) applied() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.objectsApplied++
+}
+
+func (m *Metrics) deleted() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.objectsDeleted++
+}
+
+// Whether a reconciliation has succeeded since the controller started
+func (m *Metrics) ready() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return !m.lastSuccess.IsZero()
+}
+
+// ServeHTTP : Serves the metrics
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metric := func(name string, kind string, help string, value interface{}) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+	}
+	metric("spinnaker_tools_reconcile_total", "counter", "Reconciliations run.", m.reconciles)
+	metric("spinnaker_tools_reconcile_errors_total", "counter", "Reconciliations that failed, entirely or for some service accounts.", m.reconcileErrors)
+	metric("spinnaker_tools_objects_applied_total", "counter", "Objects applied.", m.objectsApplied)
+	metric("spinnaker_tools_objects_deleted_total", "counter", "Stale RoleBindings and Roles deleted.", m.objectsDeleted)
+	metric("spinnaker_tools_service_accounts", "gauge", "Service accounts in the spec at the last successful reconciliation.", m.serviceAccounts)
+	metric("spinnaker_tools_reconcile_duration_seconds", "gauge", "Duration of the last reconciliation.", m.lastDuration.Seconds())
+	lastSuccess := int64(0)
+	if !m.lastSuccess.IsZero() {
+		lastSuccess = m.lastSuccess.Unix()
+	}
+	metric("spinnaker_tools_last_success_timestamp_seconds", "gauge", "Time of the last successful reconciliation.", lastSuccess)
+}
@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/armory/spinnaker-tools/internal/pkg/k8s"
+)
+
+// object : A Kubernetes object, as rendered from the manifest templates
+type object map[string]interface{}
+
+func (o object) str(field string) string {
+	s, _ := o[field].(string)
+	return s
+}
+
+func (o object) metadata(field string) string {
+	m, _ := o["metadata"].(map[string]interface{})
+	s, _ := m[field].(string)
+	return s
+}
+
+// API path of the object
+func (o object) path() (string, error) {
+	return resourcePath(o.str("apiVersion"), o.str("kind"), o.metadata("namespace"), o.metadata("name"))
+}
+
+func (o object) ref() k8s.ObjectRef {
+	return k8s.ObjectRef{Kind: o.str("kind"), Namespace: o.metadata("namespace"), Name: o.metadata("name")}
+}
+
+// The kind and name of the role a RoleBinding or ClusterRoleBinding binds
+func (o object) roleRef() (string, string) {
+	m, _ := o["roleRef"].(map[string]interface{})
+	kind, _ := m["kind"].(string)
+	name, _ := m["name"].(string)
+	return kind, name
+}
+
+// API path of an object of one of the kinds the manifest templates create
+func refPath(o k8s.ObjectRef) (string, error) {
+	resource, ok := resources[o.Kind]
+	if !ok {
+		return "", errors.New("unsupported kind " + o.Kind)
+	}
+	return resourcePath(resource.apiVersion, o.Kind, o.Namespace, o.Name)
+}
+
+// API version, plural resource name, and whether it is namespaced, of the kinds the manifest templates create
+var resources = map[string]struct {
+	apiVersion string
+	name       string
+	namespaced bool
+}{
+	"Namespace":          {"v1", "namespaces", false},
+	"ServiceAccount":     {"v1", "serviceaccounts", true},
+	"Secret":             {"v1", "secrets", true},
+	"ConfigMap":          {"v1", "configmaps", true},
+	"Role":               {rbacAPIVersion, "roles", true},
+	"RoleBinding":        {rbacAPIVersion, "rolebindings", true},
+	"ClusterRole":        {rbacAPIVersion, "clusterroles", false},
+	"ClusterRoleBinding": {rbacAPIVersion, "clusterrolebindings", false},
+}
+
+const rbacAPIVersion = "rbac.authorization.k8s.io/v1"
+
+// API path of an object (or, with an empty name, of a collection)
+func resourcePath(apiVersion string, kind string, namespace string, name string) (string, error) {
+	resource, ok := resources[kind]
+	if !ok {
+		return "", errors.New("unsupported kind " + kind)
+	}
+
+	path := "/apis/" + apiVersion
+	if !strings.Contains(apiVersion, "/") {
+		path = "/api/" + apiVersion
+	}
+	if resource.namespaced {
+		if namespace == "" && name != "" {
+			return "", fmt.Errorf("%s %s has no namespace", kind, name)
+		}
+		if namespace != "" {
+			path += "/namespaces/" + namespace
+		}
+	}
+	path += "/" + resource.name
+	if name != "" {
+		path += "/" + name
+	}
+	return path, nil
+}
+
+// Splits a (multi-document) YAML manifest into objects (see k8s.ManifestObjects)
+func parseObjects(manifest string) ([]object, error) {
+	maps, err := k8s.ManifestObjects(manifest)
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]object, len(maps))
+	for i, m := range maps {
+		objects[i] = object(m)
+	}
+	return objects, nil
+}
@@ -0,0 +1,321 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/armory/spinnaker-tools/internal/pkg/k8s"
+)
+
+// Reconciler : Makes the cluster match the spec in a ConfigMap
+type Reconciler struct {
+	Client *Client
+	// Namespace and name of the ConfigMap holding the spec
+	Namespace string
+	ConfigMap string
+	Metrics   *Metrics
+}
+
+type namespaceList struct {
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Status struct {
+			Phase string `json:"phase"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+type roleBindingList struct {
+	Items []k8s.RoleBindingJSON `json:"items"`
+}
+
+type clusterRoleList struct {
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+	} `json:"items"`
+}
+
+// Reconcile : Brings every service account in the spec in line with it, by doing the following for each:
+//   - Works out its target namespaces: the listed ones that exist, and the active ones matching its selector
+//   - Applies the ServiceAccount and RBAC objects CreateServiceAccount would create (see
+//     k8s.ServiceAccountManifests); the API server leaves unchanged objects untouched, and bindings
+//     whose role changed are deleted and created again, as their roleRef can't be changed
+//   - Removes the RBAC objects created for it that it no longer needs (see k8s.OwnedRBAC), such as
+//     RoleBindings in namespaces that are no longer targets, or its ClusterRoleBinding once its
+//     access is limited to target namespaces; bindings adopt took over are left alone
+//
+// Namespaces are never created, and service accounts removed from the spec are left alone
+// One failing service account doesn't stop the others from being reconciled
+func (r *Reconciler) Reconcile(ctx context.Context) error {
+	if r.Metrics == nil {
+		r.Metrics = &Metrics{}
+	}
+	start := time.Now()
+	spec, err := r.loadSpec(ctx)
+	if err != nil {
+		r.Metrics.reconciled(start, 0, err)
+		return err
+	}
+
+	namespaces, err := r.namespaces(ctx, "")
+	if err != nil {
+		r.Metrics.reconciled(start, 0, err)
+		return err
+	}
+
+	var failures []string
+	for _, entry := range spec.ServiceAccounts {
+		if err := r.reconcileServiceAccount(ctx, entry, namespaces); err != nil {
+			log.Printf("Reconciling service account %s/%s failed: %v", entry.Namespace, entry.Name, err)
+			failures = append(failures, entry.Namespace+"/"+entry.Name+": "+err.Error())
+		}
+	}
+
+	err = nil
+	if len(failures) != 0 {
+		err = errors.New(strings.Join(failures, "; "))
+	}
+	r.Metrics.reconciled(start, len(spec.ServiceAccounts), err)
+	return err
+}
+
+// Reads the spec from the ConfigMap
+func (r *Reconciler) loadSpec(ctx context.Context) (Spec, error) {
+	path, err := resourcePath("v1", "ConfigMap", r.Namespace, r.ConfigMap)
+	if err != nil {
+		return Spec{}, err
+	}
+	var configMap struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := r.Client.get(ctx, path, nil, &configMap); err != nil {
+		return Spec{}, errors.New("unable to get ConfigMap " + r.Namespace + "/" + r.ConfigMap + ": " + err.Error())
+	}
+	spec, err := ParseSpec([]byte(configMap.Data[SpecKey]))
+	if err != nil {
+		return Spec{}, errors.New("invalid " + SpecKey + " in ConfigMap " + r.Namespace + "/" + r.ConfigMap + ": " + err.Error())
+	}
+	return spec, nil
+}
+
+// Names of the active namespaces, optionally only those matching a label selector
+func (r *Reconciler) namespaces(ctx context.Context, selector string) ([]string, error) {
+	path, _ := resourcePath("v1", "Namespace", "", "")
+	query := url.Values{}
+	if selector != "" {
+		query.Set("labelSelector", selector)
+	}
+	var list namespaceList
+	if err := r.Client.get(ctx, path, query, &list); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, item := range list.Items {
+		if item.Status.Phase != "Terminating" {
+			names = append(names, item.Metadata.Name)
+		}
+	}
+	return names, nil
+}
+
+func (r *Reconciler) reconcileServiceAccount(ctx context.Context, entry ServiceAccountSpec, namespaces []string) error {
+	sa, err := entry.serviceAccount()
+	if err != nil {
+		return err
+	}
+	if !contains(namespaces, sa.Namespace) {
+		return errors.New("namespace " + sa.Namespace + " does not exist")
+	}
+
+	namespaced := sa.Namespaced()
+	sa.TargetNamespaces, err = r.targetNamespaces(ctx, sa, namespaces)
+	if err != nil {
+		return err
+	}
+	if namespaced && !sa.Namespaced() {
+		// Without any target namespace left, the manifests would grant access cluster-wide instead
+		return errors.New("none of the target namespaces exist")
+	}
+
+	// What was created for it before, as objects it no longer needs are removed once the rest is applied
+	owned, err := r.ownedRBAC(ctx, sa)
+	if err != nil {
+		return err
+	}
+
+	manifests, err := k8s.ServiceAccountManifests(sa, false)
+	if err != nil {
+		return err
+	}
+	var desired []k8s.ObjectRef
+	for _, manifest := range manifests {
+		objects, err := parseObjects(manifest)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			if err := r.deleteIfRoleChanged(ctx, obj); err != nil {
+				return err
+			}
+			if err := r.Client.apply(ctx, obj); err != nil {
+				return errors.New("unable to apply " + obj.str("kind") + " " + obj.metadata("name") + ": " + err.Error())
+			}
+			r.Metrics.applied()
+			desired = append(desired, obj.ref())
+		}
+	}
+
+	return r.removeStale(ctx, sa, owned, desired)
+}
+
+// The listed target namespaces that exist (missing ones are logged, never created), and those
+// matching the selector
+func (r *Reconciler) targetNamespaces(ctx context.Context, sa k8s.ServiceAccount, namespaces []string) ([]string, error) {
+	var targets []string
+	for _, target := range sa.TargetNamespaces {
+		if contains(namespaces, target) {
+			targets = append(targets, target)
+		} else {
+			log.Printf("Target namespace %s of service account %s/%s does not exist, skipping", target, sa.Namespace, sa.ServiceAccountName)
+		}
+	}
+	if sa.TargetNamespaceSelector != "" {
+		matching, err := r.namespaces(ctx, sa.TargetNamespaceSelector)
+		if err != nil {
+			return nil, errors.New("unable to get namespaces matching " + sa.TargetNamespaceSelector + ": " + err.Error())
+		}
+		for _, target := range matching {
+			if !contains(targets, target) {
+				targets = append(targets, target)
+			}
+		}
+	}
+	sort.Strings(targets)
+	return targets, nil
+}
+
+// The RBAC objects created for the service account, found as FindServiceAccountObjects finds them,
+// except the bindings adopt took over (see k8s.KeepAdopted), which are never removed as stale
+func (r *Reconciler) ownedRBAC(ctx context.Context, sa k8s.ServiceAccount) ([]k8s.ObjectRef, error) {
+	var byName, byLabel, clusterRoleBindings roleBindingList
+	path, _ := resourcePath(rbacAPIVersion, "RoleBinding", "", "")
+	if err := r.Client.get(ctx, path, url.Values{"fieldSelector": {"metadata.name=" + sa.NamespaceRoleBindingName()}}, &byName); err != nil {
+		return nil, errors.New("unable to get RoleBindings: " + err.Error())
+	}
+	if err := r.Client.get(ctx, path, url.Values{"labelSelector": {sa.OwnerSelector()}}, &byLabel); err != nil {
+		return nil, errors.New("unable to get RoleBindings: " + err.Error())
+	}
+	path, _ = resourcePath(rbacAPIVersion, "ClusterRoleBinding", "", "")
+	if err := r.Client.get(ctx, path, nil, &clusterRoleBindings); err != nil {
+		return nil, errors.New("unable to get ClusterRoleBindings: " + err.Error())
+	}
+	var clusterRoles clusterRoleList
+	path, _ = resourcePath(rbacAPIVersion, "ClusterRole", "", "")
+	if err := r.Client.get(ctx, path, url.Values{"labelSelector": {sa.OwnerSelector()}}, &clusterRoles); err != nil {
+		return nil, errors.New("unable to get ClusterRoles: " + err.Error())
+	}
+	var labelledClusterRoles []string
+	for _, item := range clusterRoles.Items {
+		labelledClusterRoles = append(labelledClusterRoles, item.Metadata.Name)
+	}
+
+	var serviceAccount struct {
+		Metadata struct {
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+	}
+	path, _ = resourcePath("v1", "ServiceAccount", sa.Namespace, sa.ServiceAccountName)
+	if err := r.Client.get(ctx, path, nil, &serviceAccount); err != nil && !IsNotFound(err) {
+		return nil, errors.New("unable to get ServiceAccount: " + err.Error())
+	}
+
+	found, _ := sa.OwnedRBAC(append(byName.Items, byLabel.Items...), clusterRoleBindings.Items, labelledClusterRoles)
+	var owned []k8s.ObjectRef
+	for _, o := range found {
+		if !sa.KeepAdopted(o, serviceAccount.Metadata.Annotations) {
+			owned = append(owned, o)
+		}
+	}
+	return owned, nil
+}
+
+// Deletes a RoleBinding or ClusterRoleBinding that exists with a different role than obj binds, so it
+// can be applied again, as the API server doesn't allow changing roleRef
+func (r *Reconciler) deleteIfRoleChanged(ctx context.Context, obj object) error {
+	kind := obj.str("kind")
+	if kind != "RoleBinding" && kind != "ClusterRoleBinding" {
+		return nil
+	}
+	path, err := obj.path()
+	if err != nil {
+		return err
+	}
+	live := object{}
+	err = r.Client.get(ctx, path, nil, &live)
+	if IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.New("unable to get " + kind + " " + obj.metadata("name") + ": " + err.Error())
+	}
+
+	liveKind, liveName := live.roleRef()
+	wantKind, wantName := obj.roleRef()
+	if liveKind == wantKind && liveName == wantName {
+		return nil
+	}
+	log.Printf("Replacing %s, as it binds %s %s instead of %s %s", obj.ref(), liveKind, liveName, wantKind, wantName)
+	if err := r.Client.delete(ctx, path); err != nil {
+		return err
+	}
+	r.Metrics.deleted()
+	return nil
+}
+
+// Deletes the RBAC objects created for the service account that aren't in its manifests any more, such
+// as RoleBindings (and Roles) in namespaces that aren't targets any more, or the ClusterRoleBinding (and
+// ClusterRole) of a role preset it no longer has
+func (r *Reconciler) removeStale(ctx context.Context, sa k8s.ServiceAccount, owned []k8s.ObjectRef, desired []k8s.ObjectRef) error {
+	for _, o := range owned {
+		if containsObject(desired, o) {
+			continue
+		}
+		log.Printf("Removing %s, which service account %s/%s no longer needs", o, sa.Namespace, sa.ServiceAccountName)
+		path, err := refPath(o)
+		if err != nil {
+			return err
+		}
+		if err := r.Client.delete(ctx, path); err != nil {
+			return err
+		}
+		r.Metrics.deleted()
+	}
+	return nil
+}
+
+func containsObject(objects []k8s.ObjectRef, o k8s.ObjectRef) bool {
+	for _, existing := range objects {
+		if existing == o {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"errors"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/armory/spinnaker-tools/internal/pkg/k8s"
+	"github.com/armory/spinnaker-tools/internal/pkg/version"
+)
+
+// SpecKey : Key of the spec in the controller's ConfigMap
+const SpecKey = "spec.yaml"
+
+// Spec : The service accounts the controller keeps in sync, stored in a ConfigMap
+type Spec struct {
+	ServiceAccounts []ServiceAccountSpec `yaml:"serviceAccounts"`
+}
+
+// ServiceAccountSpec : A service account, and the access it is granted, as create-service-account
+// would set it up
+type ServiceAccountSpec struct {
+	Namespace    string `yaml:"namespace"`
+	Name         string `yaml:"name"`
+	Role         string `yaml:"role"`
+	AllowSecrets bool   `yaml:"allowSecrets"`
+	// Both are optional; with neither, access is granted cluster-wide
+	TargetNamespaces        []string `yaml:"targetNamespaces"`
+	TargetNamespaceSelector string   `yaml:"targetNamespaceSelector"`
+	NamespaceBinding        string   `yaml:"namespaceBinding"`
+}
+
+// ParseSpec : Parses and validates a spec
+func ParseSpec(b []byte) (Spec, error) {
+	var spec Spec
+	if err := yaml.UnmarshalStrict(b, &spec); err != nil {
+		return Spec{}, err
+	}
+	for _, entry := range spec.ServiceAccounts {
+		if _, err := entry.serviceAccount(); err != nil {
+			return Spec{}, err
+		}
+	}
+	return spec, nil
+}
+
+// The service account for an entry, without the target namespaces matching its selector
+func (s ServiceAccountSpec) serviceAccount() (k8s.ServiceAccount, error) {
+	if s.Namespace == "" || s.Name == "" {
+		return k8s.ServiceAccount{}, errors.New("service accounts in the spec need a namespace and a name")
+	}
+
+	sa := k8s.ServiceAccount{
+		Namespace:               s.Namespace,
+		ServiceAccountName:      s.Name,
+		AllowSecrets:            s.AllowSecrets,
+		TargetNamespaces:        append([]string{}, s.TargetNamespaces...),
+		TargetNamespaceSelector: s.TargetNamespaceSelector,
+		// No user or time: they would change every object at every reconciliation
+		Provenance: k8s.Provenance{Version: version.Version, Command: "controller"},
+	}
+	var err error
+	if sa.Role, err = k8s.ParseRole(s.Role); err != nil {
+		return k8s.ServiceAccount{}, err
+	}
+	if sa.NamespaceBinding, err = k8s.ParseNamespaceBinding(s.NamespaceBinding); err != nil {
+		return k8s.ServiceAccount{}, err
+	}
+	if sa.Role == "" && !(sa.Namespaced() && sa.NamespaceBinding.BuiltIn()) {
+		sa.Role = k8s.ClusterAdminRole
+	}
+	if sa.Namespaced() && sa.NamespaceBinding == "" {
+		sa.NamespaceBinding = k8s.RolePerNamespace
+	}
+	return sa, nil
+}
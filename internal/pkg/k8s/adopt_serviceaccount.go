@@ -0,0 +1,236 @@
+package k8s
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/armory/spinnaker-tools/internal/pkg/utils"
+	"github.com/fatih/color"
+)
+
+// AdoptedBindingsAnnotation : On a ServiceAccount taken over by adopt, the bindings it had, as
+// "Kind namespace/name" separated by commas
+const AdoptedBindingsAnnotation = annotationPrefix + "adopted-bindings"
+
+// CustomRole : Shown by list as the role of an adopted service account whose bindings aren't a role preset
+const CustomRole = "custom"
+
+// Binding : A RoleBinding or ClusterRoleBinding whose subjects include a service account
+type Binding struct {
+	Object ObjectRef `json:"binding" yaml:"binding"`
+	// The Role (in the namespace of its RoleBinding) or ClusterRole it binds
+	Role ObjectRef `json:"role" yaml:"role"`
+	// Whether it also binds other subjects, so it isn't the service account's alone
+	Shared bool `json:"shared" yaml:"shared"`
+}
+
+// FindBindings : Finds every RoleBinding (in any namespace) and ClusterRoleBinding whose subjects include
+// the service account, whatever they are named and however they were created
+// With indirect, bindings to its user name (system:serviceaccount:<namespace>:<name>), and to the groups
+// every service account (or every one in its namespace) and every authenticated user are in, are
+// included too; those to groups are shared
+// Returns bindings, error string, error
+func (c *Cluster) FindBindings(sa ServiceAccount, indirect bool, verbose bool) ([]Binding, string, error) {
+	var bindings []Binding
+	for _, kind := range []string{"ClusterRoleBinding", "RoleBinding"} {
+		command := []string{"get", strings.ToLower(kind) + "s"}
+		if kind == "RoleBinding" {
+			command = append(command, "--all-namespaces")
+		}
+		var items roleBindingsJSON
+		serr, err := c.getJSON(command, &items, verbose)
+		if err != nil {
+			return nil, "Unable to get " + kind + "s:\n" + serr, err
+		}
+		for _, item := range items.Items {
+			included, shared := subjectsInclude(item.Subjects, sa, indirect)
+			if !included {
+				continue
+			}
+			b := Binding{
+				Object: ObjectRef{kind, item.Metadata.Namespace, item.Metadata.Name},
+				Role:   ObjectRef{item.RoleRef.Kind, "", item.RoleRef.Name},
+				Shared: shared,
+			}
+			if item.RoleRef.Kind == "Role" {
+				b.Role.Namespace = item.Metadata.Namespace
+			}
+			bindings = append(bindings, b)
+		}
+	}
+	return bindings, "", nil
+}
+
+// Whether the subjects of a binding include the service account (see FindBindings), and whether they
+// include anything else
+func subjectsInclude(subjects []subjectJSON, sa ServiceAccount, indirect bool) (bool, bool) {
+	groups := []string{"system:serviceaccounts", "system:serviceaccounts:" + sa.Namespace, "system:authenticated"}
+	included, shared := false, false
+	for _, subject := range subjects {
+		switch {
+		case bindsServiceAccount([]subjectJSON{subject}, sa):
+			included = true
+		case indirect && subject.Kind == "User" && subject.Name == "system:serviceaccount:"+sa.Namespace+":"+sa.ServiceAccountName:
+			included = true
+		case indirect && subject.Kind == "Group" && contains(groups, subject.Name):
+			included, shared = true, true
+		default:
+			shared = true
+		}
+	}
+	return included, shared
+}
+
+// AdoptionSummary : Describes what AdoptServiceAccount does, one line each
+func AdoptionSummary(sa ServiceAccount, bindings []Binding) []string {
+	lines := []string{"adopt " + ObjectRef{"ServiceAccount", sa.Namespace, sa.ServiceAccountName}.String()}
+	for _, b := range bindings {
+		if b.Shared {
+			lines = append(lines, "skip  "+b.Object.String()+" (also binds other subjects)")
+		} else {
+			lines = append(lines, "adopt "+b.Object.String()+" (to "+b.Role.String()+")")
+		}
+	}
+	annotations := adoptedAnnotations(bindings)
+	switch {
+	case annotations[RoleAnnotation] != "":
+		lines = append(lines, "record role: "+annotations[RoleAnnotation])
+	case annotations[NamespaceBindingAnnotation] != "":
+		lines = append(lines, "record namespace binding: "+annotations[NamespaceBindingAnnotation])
+	default:
+		lines = append(lines, "record role: "+CustomRole+" (the bindings aren't a role preset)")
+	}
+	return lines
+}
+
+// AdoptServiceAccount : Takes over a service account created some other way, so that list,
+// delete-service-account, create-kubeconfig and create-service-account treat it like one created by
+// this tool:
+//   - Labels the ServiceAccount, and the bindings found by FindBindings, as CreateServiceAccount does,
+//     and annotates them with the service account's Provenance
+//   - Records on the ServiceAccount the access its bindings grant, as the role preset or namespace binding
+//     annotations if they match one, and the bindings adopted
+//
+// Bindings that also bind other subjects aren't adopted, so deleting the service account never removes
+// access from anything else; Roles and ClusterRoles are left alone, as they may be shared too
+// Returns error string, error
+func (c *Cluster) AdoptServiceAccount(sa ServiceAccount, bindings []Binding, verbose bool) (string, error) {
+	objects := []ObjectRef{{"ServiceAccount", sa.Namespace, sa.ServiceAccountName}}
+	for _, b := range bindings {
+		if !b.Shared {
+			objects = append(objects, b.Object)
+		}
+	}
+
+	for i, o := range objects {
+		annotations := sa.ownerAnnotations()
+		// Everything about the service account as a whole is recorded on the ServiceAccount
+		if i == 0 {
+			for key, value := range adoptedAnnotations(bindings) {
+				annotations[key] = value
+			}
+		}
+		if serr, err := c.setMetadata(o, "label", sa.ownerLabels(), verbose); err != nil {
+			return serr, err
+		}
+		if serr, err := c.setMetadata(o, "annotate", annotations, verbose); err != nil {
+			return serr, err
+		}
+		color.Green("Adopted %s", o)
+	}
+	return "", nil
+}
+
+// The annotations recording the access the bindings grant, as CreateServiceAccount would have
+// Called by AdoptServiceAccount
+func adoptedAnnotations(bindings []Binding) map[string]string {
+	annotations := map[string]string{}
+	var adopted []string
+	clusterAdmin := false
+	var namespaceRoles []string
+	for _, b := range bindings {
+		if b.Shared {
+			continue
+		}
+		adopted = append(adopted, b.Object.String())
+		if b.Object.Kind == "ClusterRoleBinding" {
+			clusterAdmin = clusterAdmin || b.Role == ObjectRef{"ClusterRole", "", string(ClusterAdminRole)}
+			namespaceRoles = append(namespaceRoles, "")
+		} else {
+			namespaceRoles = append(namespaceRoles, b.Role.Kind+" "+b.Role.Name)
+		}
+	}
+	sort.Strings(adopted)
+	annotations[AdoptedBindingsAnnotation] = strings.Join(adopted, ", ")
+
+	// cluster-admin makes anything else it's bound to irrelevant
+	if clusterAdmin {
+		annotations[RoleAnnotation] = string(ClusterAdminRole)
+		return annotations
+	}
+	// Every binding a RoleBinding to the same built-in ClusterRole (edit, admin or view)
+	builtIn := ""
+	for i, role := range namespaceRoles {
+		if i == 0 {
+			builtIn = strings.TrimPrefix(role, "ClusterRole ")
+		} else if role != namespaceRoles[0] {
+			builtIn = ""
+		}
+	}
+	if binding, err := ParseNamespaceBinding(builtIn); err == nil && binding.BuiltIn() {
+		annotations[NamespaceBindingAnnotation] = string(binding)
+	}
+	return annotations
+}
+
+// AdoptedBindings : The bindings recorded in the AdoptedBindingsAnnotation of a ServiceAccount's annotations
+func AdoptedBindings(annotations map[string]string) []ObjectRef {
+	var bindings []ObjectRef
+	for _, s := range strings.Split(annotations[AdoptedBindingsAnnotation], ",") {
+		parts := strings.SplitN(strings.TrimSpace(s), " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		o := ObjectRef{Kind: parts[0], Name: parts[1]}
+		if i := strings.Index(o.Name, "/"); i != -1 {
+			o.Namespace, o.Name = o.Name[:i], o.Name[i+1:]
+		}
+		bindings = append(bindings, o)
+	}
+	return bindings
+}
+
+// KeepAdopted : Whether an object found for the service account (see OwnedRBAC) is a binding adopt took
+// over, given the annotations of its ServiceAccount, rather than one CreateServiceAccount creates
+// Such bindings aren't in the manifests, but are only deleted with the service account, never as stale
+func (sa ServiceAccount) KeepAdopted(o ObjectRef, annotations map[string]string) bool {
+	switch {
+	case o.Kind == "RoleBinding" && o.Name == sa.NamespaceRoleBindingName():
+		return false
+	case o.Kind == "ClusterRoleBinding" && contains(sa.clusterRoleBindingNames(), o.Name):
+		return false
+	}
+	return containsObject(AdoptedBindings(annotations), o)
+}
+
+// Labels or annotates an object, replacing values it already has
+// Returns error string, error
+func (c *Cluster) setMetadata(o ObjectRef, verb string, values map[string]string, verbose bool) (string, error) {
+	command := []string{verb, strings.ToLower(o.Kind), o.Name, "--overwrite"}
+	if o.Namespace != "" {
+		command = append(command, "-n", o.Namespace)
+	}
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		command = append(command, key+"="+values[key])
+	}
+	_, bserr, err := utils.RunCommand(verbose, "kubectl", c.buildCommand(command, verbose)...)
+	if err != nil {
+		return "Unable to " + verb + " " + o.String() + ":\n" + bserr.String(), err
+	}
+	return "", nil
+}
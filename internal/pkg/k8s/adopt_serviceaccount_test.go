@@ -0,0 +1,49 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdoptedAnnotations(t *testing.T) {
+	view := []Binding{
+		{Object: ObjectRef{"RoleBinding", "b", "ci-view"}, Role: ObjectRef{"ClusterRole", "", "view"}},
+		{Object: ObjectRef{"RoleBinding", "a", "ci-view"}, Role: ObjectRef{"ClusterRole", "", "view"}},
+		{Object: ObjectRef{"RoleBinding", "c", "everyone"}, Role: ObjectRef{"ClusterRole", "", "admin"}, Shared: true},
+	}
+	assert.Equal(t, map[string]string{
+		AdoptedBindingsAnnotation:  "RoleBinding a/ci-view, RoleBinding b/ci-view",
+		NamespaceBindingAnnotation: "view",
+	}, adoptedAnnotations(view))
+
+	admin := append(view, Binding{Object: ObjectRef{"ClusterRoleBinding", "", "ci-admin"}, Role: ObjectRef{"ClusterRole", "", "cluster-admin"}})
+	assert.Equal(t, "cluster-admin", adoptedAnnotations(admin)[RoleAnnotation])
+	assert.NotContains(t, adoptedAnnotations(admin), NamespaceBindingAnnotation)
+
+	mixed := append(view, Binding{Object: ObjectRef{"RoleBinding", "d", "ci"}, Role: ObjectRef{"Role", "d", "view"}})
+	assert.Equal(t, map[string]string{
+		AdoptedBindingsAnnotation: "RoleBinding a/ci-view, RoleBinding b/ci-view, RoleBinding d/ci",
+	}, adoptedAnnotations(mixed))
+}
+
+func TestAdoptionSummary(t *testing.T) {
+	sa := ServiceAccount{Namespace: "ci", ServiceAccountName: "deployer"}
+	assert.Equal(t, []string{
+		"adopt ServiceAccount ci/deployer",
+		"adopt ClusterRoleBinding deployer (to ClusterRole deployer)",
+		"skip  RoleBinding apps/team (also binds other subjects)",
+		"record role: custom (the bindings aren't a role preset)",
+	}, AdoptionSummary(sa, []Binding{
+		{Object: ObjectRef{"ClusterRoleBinding", "", "deployer"}, Role: ObjectRef{"ClusterRole", "", "deployer"}},
+		{Object: ObjectRef{"RoleBinding", "apps", "team"}, Role: ObjectRef{"Role", "apps", "team"}, Shared: true},
+	}))
+}
+
+func TestAdoptedBindings(t *testing.T) {
+	assert.Equal(t, []ObjectRef{
+		{"ClusterRoleBinding", "", "legacy-crb"},
+		{"RoleBinding", "apps", "ci-edit"},
+	}, AdoptedBindings(map[string]string{AdoptedBindingsAnnotation: "ClusterRoleBinding legacy-crb, RoleBinding apps/ci-edit"}))
+	assert.Empty(t, AdoptedBindings(nil))
+}
@@ -0,0 +1,173 @@
+package k8s
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/armory/spinnaker-tools/internal/pkg/utils"
+	"github.com/fatih/color"
+)
+
+// The API server rejects CertificateSigningRequests asking for less than 10 minutes
+const minCertificateDuration = 10 * time.Minute
+
+// Values for the certificateSigningRequest template
+type certificateRequest struct {
+	Name              string
+	Request           string
+	ExpirationSeconds int64
+}
+
+// Gets a client certificate for the service account through the CertificateSigningRequest API:
+// * Generates a private key and a CSR for the service account's user name
+// * Submits the CSR to the kube-apiserver-client signer
+// * Approves it, if we're allowed to (otherwise waits for someone else to)
+// * Waits for the signed certificate
+// * Deletes the CSR, whether or not it was signed, as the private key it is for is never stored, so
+//   nothing else can use it
+// Returns PEM certificate, PEM private key, error string, error
+// Called by getCredential
+func (c *Cluster) getClientCertificate(sa ServiceAccount, verbose bool) ([]byte, []byte, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, "Unable to generate private key", err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, "Unable to encode private key", err
+	}
+
+	// The API server maps the certificate's CN and O to user name and groups, so this certificate
+	// authenticates exactly as the service account would (and gets its RBAC permissions)
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName:   "system:serviceaccount:" + sa.Namespace + ":" + sa.ServiceAccountName,
+			Organization: []string{"system:serviceaccounts", "system:serviceaccounts:" + sa.Namespace},
+		},
+	}, key)
+	if err != nil {
+		return nil, nil, "Unable to create certificate signing request", err
+	}
+
+	csr := certificateRequest{
+		Name:    fmt.Sprintf("%s-%s-%d", sa.Namespace, sa.ServiceAccountName, time.Now().Unix()),
+		Request: base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})),
+	}
+	if sa.Certificate.Duration != 0 {
+		if sa.Certificate.Duration < minCertificateDuration {
+			return nil, nil, fmt.Sprintf("Certificate duration must be at least %s", minCertificateDuration), errors.New("certificate duration too short")
+		}
+		csr.ExpirationSeconds = int64(sa.Certificate.Duration / time.Second)
+	}
+
+	manifest, err := certificateSigningRequest(csr, verbose)
+	if err != nil {
+		return nil, nil, "Unable to render CertificateSigningRequest", err
+	}
+
+	color.Blue("Submitting CertificateSigningRequest %s ...", csr.Name)
+	options := c.buildCommand([]string{
+		"create", "-f", "-",
+	}, verbose)
+	err = utils.RunCommandInput(verbose, "kubectl", manifest, options...)
+	if err != nil {
+		return nil, nil, "Unable to create CertificateSigningRequest " + csr.Name, err
+	}
+	defer c.deleteCertificateSigningRequest(csr.Name, verbose)
+
+	color.Blue("Approving CertificateSigningRequest %s ...", csr.Name)
+	options = c.buildCommand([]string{
+		"certificate", "approve", csr.Name,
+	}, verbose)
+	_, bserr, err := utils.RunCommand(verbose, "kubectl", options...)
+	if err != nil {
+		color.Yellow("Not allowed to approve CertificateSigningRequest %s:\n%s", csr.Name, bserr.String())
+		color.Yellow("Ask a cluster administrator to run `kubectl certificate approve %s`", csr.Name)
+	}
+
+	cert, serr, err := c.waitForCertificate(sa, csr.Name, verbose)
+	if err != nil {
+		return nil, nil, serr, err
+	}
+
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), "", nil
+}
+
+// Polls the CertificateSigningRequest until it is signed, denied or failed, or sa.Certificate.Timeout passes
+// Returns PEM certificate, error string, error
+// Called by getClientCertificate
+func (c *Cluster) waitForCertificate(sa ServiceAccount, name string, verbose bool) ([]byte, string, error) {
+	options := c.buildCommand([]string{
+		"get", "certificatesigningrequest", name,
+		"-o=json",
+	}, verbose)
+
+	color.Blue("Waiting up to %s for CertificateSigningRequest %s to be signed ...", sa.Certificate.Timeout, name)
+	start := time.Now()
+	for {
+		o, bserr, err := utils.RunCommand(verbose, "kubectl", options...)
+		if err != nil {
+			return nil, "Unable to get CertificateSigningRequest " + name + ":\n" + bserr.String(), err
+		}
+
+		var csr certificateSigningRequestJSON
+		if err := json.NewDecoder(o).Decode(&csr); err != nil {
+			return nil, "Cannot decode JSON for CertificateSigningRequest " + name, err
+		}
+
+		for _, condition := range csr.Status.Conditions {
+			if condition.Type == "Denied" || condition.Type == "Failed" {
+				return nil, fmt.Sprintf("CertificateSigningRequest %s was %s: %s %s", name, condition.Type, condition.Reason, condition.Message),
+					errors.New("certificate signing request " + condition.Type)
+			}
+		}
+
+		if csr.Status.Certificate != "" {
+			cert, err := base64.StdEncoding.DecodeString(csr.Status.Certificate)
+			if err != nil {
+				return nil, "Unable to decode certificate of CertificateSigningRequest " + name, err
+			}
+			color.Green("CertificateSigningRequest %s signed", name)
+			return cert, "", nil
+		}
+
+		elapsed := time.Since(start)
+		if elapsed >= sa.Certificate.Timeout {
+			return nil, fmt.Sprintf("CertificateSigningRequest %s was not signed within %s", name, sa.Certificate.Timeout),
+				errors.New("timed out waiting for certificate")
+		}
+		fmt.Printf("Certificate not issued yet (%s elapsed) ...\n", elapsed.Round(time.Second))
+		time.Sleep(sa.Certificate.PollInterval)
+	}
+}
+
+// Deletes the CertificateSigningRequest once getClientCertificate is done with it; failing to is only
+// a warning, as the certificate (if any) has already been read
+func (c *Cluster) deleteCertificateSigningRequest(name string, verbose bool) {
+	if serr, err := c.deleteObject(ObjectRef{"CertificateSigningRequest", "", name}, verbose); err != nil {
+		color.Yellow("Unable to clean up: %s", serr)
+	}
+}
+
+// Human readable expiry of a PEM certificate, for output
+func certificateExpiry(certPEM []byte) string {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return "unknown"
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "unknown"
+	}
+	return cert.NotAfter.Format(time.RFC3339) + " (" + strconv.Itoa(int(time.Until(cert.NotAfter).Hours())) + "h)"
+}
@@ -1,14 +1,15 @@
 package k8s
 
 import (
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"os"
-	"os/exec"
-	"strconv"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
 
@@ -16,204 +17,240 @@ import (
 	"github.com/armory/spinnaker-tools/internal/pkg/utils"
 )
 
-// CreateKubeconfigUsingKubectl : Creates the kubeconfig, by doing the following:
-// * Get the token for the service account
-// * Clone the current kubeconfig
-// * Update the kubeconfig with the following:
-//   * Rename relevant context to spinnaker
-//   * Switching to spinnaker context
-//   * Adding the token to the kubeconfig as a new user
-//   * Updating the spinnaker context to use the new user
-//   * Updating the spinnaker context to the correct namespace
-// * Generates a minified kubeconfig from the above
-// Returns full path to created kubeconfig file, string error, error
-func (c *Cluster) CreateKubeconfigUsingKubectl(ctx diagnostics.Handler, filename string, sa ServiceAccount, verbose bool) (string, string, error) {
+// credential : What the kubeconfig user for the service account authenticates with
+// Either Token or ClientCertificate/ClientKey (PEM) are set
+type credential struct {
+	Token             string
+	ClientCertificate []byte
+	ClientKey         []byte
+}
+
+// Name of the kubeconfig user holding the credential
+func (cr credential) userName() string {
+	if cr.Token != "" {
+		return "spinnaker-token-user"
+	}
+	return "spinnaker-cert-user"
+}
+
+// What kind of credential it is: a client certificate, a token requested through the TokenRequest API,
+// or a (non-expiring) token from a token Secret
+func (cr credential) kind() string {
+	if cr.Token == "" {
+		return "certificate"
+	}
+	if cr.expiry().IsZero() {
+		return "token-secret"
+	}
+	return "token"
+}
+
+// When the credential expires; zero if it doesn't (or it can't be told)
+func (cr credential) expiry() time.Time {
+	if cr.Token == "" {
+		block, _ := pem.Decode(cr.ClientCertificate)
+		if block == nil {
+			return time.Time{}
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return time.Time{}
+		}
+		return cert.NotAfter
+	}
+
+	// Tokens are JWTs; only requested ones have an exp claim
+	parts := strings.Split(cr.Token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0).UTC()
+}
+
+// Gets the credential for the service account, as chosen by sa.Credentials
+// Returns credential, error string, error
+// Called by CreateKubeconfig
+func (c *Cluster) getCredential(ctx diagnostics.Handler, sa ServiceAccount, verbose bool) (credential, string, error) {
+	if sa.Credentials == CertificateCredentials {
+		color.Blue("Getting client certificate for service account ... ")
+		cert, key, serr, err := c.getClientCertificate(sa, verbose)
+		if err != nil {
+			serr = "Unable to obtain client certificate for service account. Check you have access to create CertificateSigningRequests.\n" + serr
+			ctx.Error(serr, err)
+			return credential{}, serr, err
+		}
+		color.Green("Client certificate expires %s", certificateExpiry(cert))
+		return credential{ClientCertificate: cert, ClientKey: key}, "", nil
+	}
+
 	color.Blue("Getting token for service account ... ")
 	token, serr, err := c.getToken(sa, verbose)
-	// fmt.Println(token)
 	if err != nil {
 		serr = "Unable to obtain token for service account. Check you have access to the service account created.\n" + serr
 		ctx.Error(serr, err)
-		return "", serr, err
+		return credential{}, serr, err
 	}
+	return credential{Token: token}, "", nil
+}
 
-	// Clone kubeconfig
-	color.Blue("Cloning kubeconfig ... ")
-	bserr, err := utils.RunCommandToFile(verbose, "kubectl", filename+".tmp",
-		"--kubeconfig", c.KubeconfigFile,
-		"config",
-		"view", "--raw")
+// CreateKubeconfig : Creates the kubeconfig, by doing the following:
+// * Get the token or client certificate for the service account
+// * Load the current kubeconfig
+// * Build a minimal kubeconfig from it, with a single `spinnaker` (or `spinnaker-readonly`) context made of:
+//   * The cluster of the selected context, with certificate authority files inlined
+//   * A new user with the token or client certificate
+//   * The service account's namespace
+// * Writes it to a file
+// Everything happens in memory, so neither the source kubeconfig's credentials nor the new ones
+// are written anywhere except the output file
+// Returns full path to created kubeconfig file, string error, error
+func (c *Cluster) CreateKubeconfig(ctx diagnostics.Handler, filename string, sa ServiceAccount, verbose bool) (string, string, error) {
+	cred, serr, err := c.getCredential(ctx, sa, verbose)
 	if err != nil {
-		return "", "Unable to clone kubeconfig:\n" + bserr.String(), err
+		return "", serr, err
 	}
+	c.recordCredential(sa, cred, verbose)
 
-	// Rename context
-	color.Blue("Renaming context in kubeconfig ... ")
-	o, bserr, err := utils.RunCommand(verbose, "kubectl",
-		"--kubeconfig", filename+".tmp",
-		"config",
-		"rename-context", c.Context.ContextName, "spinnaker")
+	color.Blue("Loading kubeconfig ... ")
+	source, err := loadKubeconfig(c.KubeconfigFile)
 	if err != nil {
-		return "", "Unable to rename kubeconfig context:\n" + bserr.String(), err
+		return "", "Unable to load kubeconfig " + c.KubeconfigFile, err
 	}
 
-	if verbose {
-		color.Yellow(o.String())
+	color.Blue("Building kubeconfig ... ")
+	kc, err := source.extract(c.Context.ContextName, KubeconfigContext(sa), sa.Namespace, cred, filepath.Dir(c.KubeconfigFile))
+	if err != nil {
+		return "", "Unable to build kubeconfig for context " + c.Context.ContextName, err
 	}
-
-	// Switch context
-	color.Blue("Switching context in kubeconfig ... ")
-	o, bserr, err = utils.RunCommand(verbose, "kubectl",
-		"--kubeconfig", filename+".tmp",
-		"config",
-		"use-context", "spinnaker")
+	b, err := kc.marshal()
 	if err != nil {
-		return "", "Unable to switch kubeconfig context:\n" + bserr.String(), err
+		return "", "Unable to serialize kubeconfig", err
 	}
 
-	if verbose {
-		color.Yellow(o.String())
-	}
+	color.Blue("Writing kubeconfig ... ")
+	return writeKubeconfigFile(string(b), filename, verbose)
+}
 
-	// Create token user
-	color.Blue("Creating token user in kubeconfig ... ")
-	o, bserr, err = utils.RunCommand(verbose, "kubectl",
-		"--kubeconfig", filename+".tmp",
-		"config",
-		"set-credentials", "spinnaker-token-user", "--token", token)
-	if err != nil {
-		return "", "Unable to create token user:\n" + bserr.String(), err
+// Annotates the service account with the kind of credential put in the kubeconfig, and when it was
+// issued and expires, for list
+// Only a warning if it fails, as the kubeconfig works regardless
+// Called by CreateKubeconfig
+func (c *Cluster) recordCredential(sa ServiceAccount, cred credential, verbose bool) {
+	command := []string{
+		"annotate", "serviceaccount", sa.ServiceAccountName,
+		"-n", sa.Namespace,
+		"--overwrite",
+		CredentialAnnotation + "=" + cred.kind(),
+		CredentialIssuedAnnotation + "=" + time.Now().UTC().Format(time.RFC3339),
 	}
-
-	if verbose {
-		color.Yellow(o.String())
+	if expiry := cred.expiry(); !expiry.IsZero() {
+		command = append(command, CredentialExpiresAnnotation+"="+expiry.Format(time.RFC3339))
+	} else {
+		command = append(command, CredentialExpiresAnnotation+"-")
 	}
 
-	// Update context to use token user
-	color.Blue("Updating context to use token user in kubeconfig ... ")
-	o, bserr, err = utils.RunCommand(verbose, "kubectl",
-		"--kubeconfig", filename+".tmp",
-		"config",
-		"set-context", "spinnaker", "--user", "spinnaker-token-user")
+	_, bserr, err := utils.RunCommand(verbose, "kubectl", c.buildCommand(command, verbose)...)
 	if err != nil {
-		return "", "Unable to modify context:\n" + bserr.String(), err
-	}
-
-	if verbose {
-		color.Yellow(o.String())
+		color.Yellow("Unable to record the credential on service account %s:\n%s", sa.ServiceAccountName, bserr.String())
 	}
+}
 
-	// Switch context namespace
-	color.Blue("Updating context with namespace in kubeconfig ... ")
-	o, bserr, err = utils.RunCommand(verbose, "kubectl",
-		"--kubeconfig", filename+".tmp",
-		"config",
-		"set-context", "spinnaker", "--namespace", sa.Namespace)
+// Returns token, error string, error
+// Called by getCredential
+func (c *Cluster) getToken(sa ServiceAccount, verbose bool) (string, string, error) {
+	secretName, serr, err := c.findTokenSecret(sa, verbose)
 	if err != nil {
-		return "", "Unable to modify context:\n" + bserr.String(), err
+		return "", serr, err
 	}
 
-	if verbose {
-		color.Yellow(o.String())
+	if secretName != "" {
+		return c.getSecretToken(sa, secretName, verbose)
 	}
 
-	// Minify
-	color.Blue("Minifying kubeconfig ... ")
-	bserr, err = utils.RunCommandToFile(verbose, "kubectl", filename,
-		"--kubeconfig", filename+".tmp",
-		"config",
-		"view", "--flatten", "--minify")
+	version, err := GetKubectlVersion(verbose)
 	if err != nil {
-		return "", "Unable to clone kubeconfig:\n" + bserr.String(), err
+		return "", "Unable to get kubectl version", err
 	}
-
-	color.Blue("Deleting temp kubeconfig ... ")
-	err = os.Remove(filename + ".tmp")
+	clientMinor, err := version.ClientVersion.GetMinorVersionInt()
 	if err != nil {
-		return "", "Unable to remove tmp kubeconfig", err
+		return "", "Unable to get minor version of kubectl", err
 	}
-
-	return filename, "", nil
+	if clientMinor < minTokenRequestKubectlMinor {
+		return "", fmt.Sprintf("Service account has no token secret, and requesting a token requires kubectl 1.%d or newer", minTokenRequestKubectlMinor),
+			errors.New("kubectl does not support `create token`")
+	}
+	return c.requestToken(sa, verbose)
 }
 
-// CreateKubeconfig : Creates the kubeconfig, by doing the following:
-// TODO: Decide if we wanna remove this code, and dependent code
-// * Get the token for the service account
-// * Get information about the current kubeconfig
-// * Generates a kubeconfig from the above
-// * Writes it to a file
-// Returns full path to created kubeconfig file, string error, error
-// func (c *Cluster) CreateKubeconfig(ctx diagnostics.Handler, filename string, sa ServiceAccount, verbose bool) (string, string, error) {
-// 	color.Blue("Getting token for service account ... ")
-// 	token, serr, err := c.getToken(sa, verbose)
-// 	if err != nil {
-// 		serr = "Unable to obtain token for service account. Check you have access to the service account created.\n" + serr
-// 		ctx.Error(serr, err)
-// 		return "", serr, err
-// 	}
-
-// 	color.Blue("Getting cluster info ... ")
-// 	srv, ca, serr, err := c.getClusterInfo(verbose)
-// 	if err != nil {
-// 		serr = "Failed to get cluster info:\n" + serr
-// 		return "", serr, err
-// 	}
-
-// 	sac := serviceAccountContext{
-// 		Alias:  sa.Namespace + "-" + sa.ServiceAccountName,
-// 		Token:  token,
-// 		Server: srv,
-// 		CA:     ca,
-// 	}
-
-// 	color.Blue("Building kubeconfig ... ")
-// 	kc, serr, err := buildKubeconfig(sac, verbose)
-// 	if err != nil {
-// 		serr = "Failed to build kubeconfig:\n" + serr
-// 		return "", serr, err
-// 	}
-
-// 	color.Blue("Writing kubeconfig ... ")
-// 	// fmt.Println(kc)
-// 	f, serr, err := writeKubeconfigFile(kc, filename, verbose)
-// 	if err != nil {
-// 		serr = "Failed to write kubeconfig:\n" + serr
-// 		return "", serr, err
-// 	}
-
-// 	color.Blue("Checking connectivity to the cluster ...")
-// 	err = checkKubeConfigConnectivity(f, verbose)
-// 	if err != nil {
-// 		serr = "Connection with generated kubeconfig failed:\n" + serr
-// 		ctx.Error("Unable to make a kubeconfig for the selected cluster", err)
-// 		return "", serr, err
-// 	}
-
-// 	return f, "", nil
-// }
+// Works out where the token for the service account comes from:
+// * If a token Secret is named in sa.Token, that Secret
+// * On clusters older than 1.24, the token Secret created automatically for the service account
+// * Otherwise (or if there is no such Secret), nowhere: a token is minted through the TokenRequest API
+// Returns Secret name ("" to request a token), error string, error
+// Called by getToken
+func (c *Cluster) findTokenSecret(sa ServiceAccount, verbose bool) (string, string, error) {
+	if sa.Token.SecretName != "" {
+		return sa.Token.SecretName, "", nil
+	}
 
-// Returns token, error string, error
-// Called by CreateKubeconfig and CreateKubeconfigUsingKubectl
-func (c *Cluster) getToken(sa ServiceAccount, verbose bool) (string, string, error) {
-	options1 := c.buildCommand([]string{
+	options := c.buildCommand([]string{
 		"get", "serviceaccount", sa.ServiceAccountName,
 		"-n", sa.Namespace,
 		"-o", "jsonpath={.secrets[0].name}",
 	}, verbose)
 
-	o, bserr, err := utils.RunCommand(verbose, "kubectl", options1...)
+	o, bserr, err := utils.RunCommand(verbose, "kubectl", options...)
 	if err != nil {
 		serr := "Get secret name failed:\n" + bserr.String()
 		return "", serr, err
 	}
+	secretName := strings.TrimSpace(o.String())
+
+	serverMinor, serr, err := c.getServerMinorVersion(verbose)
+	if err != nil {
+		return "", serr, err
+	}
+
+	if useTokenRequest(serverMinor, secretName) {
+		return "", "", nil
+	}
+	return secretName, "", nil
+}
+
+// Kubernetes stopped auto-creating token Secrets for service accounts in 1.24
+const noTokenSecretsMinor = 24
+
+// `kubectl create token` was added in 1.24
+const minTokenRequestKubectlMinor = 24
+
+// Decides whether a token should be minted through the TokenRequest API, rather than read from
+// the (legacy) auto-created token Secret
+// Called by getToken
+func useTokenRequest(serverMinor int, secretName string) bool {
+	return secretName == "" || serverMinor >= noTokenSecretsMinor
+}
 
-	options2 := c.buildCommand([]string{
-		"get", "secret", o.String(),
+// Reads and decodes the token stored in a service account token Secret
+// Returns token, error string, error
+// Called by getToken
+func (c *Cluster) getSecretToken(sa ServiceAccount, secretName string, verbose bool) (string, string, error) {
+	options := c.buildCommand([]string{
+		"get", "secret", secretName,
 		"-n", sa.Namespace,
 		"-o", "jsonpath={.data.token}",
 	}, verbose)
 
-	t, bserr, err := utils.RunCommand(verbose, "kubectl", options2...)
+	t, bserr, err := utils.RunCommand(verbose, "kubectl", options...)
 	if err != nil {
 		serr := "Get secret failed:\n" + bserr.String()
 		return "", serr, err
@@ -222,90 +259,47 @@ func (c *Cluster) getToken(sa ServiceAccount, verbose bool) (string, string, err
 	if err != nil {
 		return "", "", err
 	}
+	if len(b) == 0 {
+		return "", "Token secret " + secretName + " has not been populated with a token", errors.New("empty token")
+	}
 	return string(b), "", nil
 }
 
+// Mints a token for the service account through the TokenRequest API
+// Returns token, error string, error
+// Called by getToken
+func (c *Cluster) requestToken(sa ServiceAccount, verbose bool) (string, string, error) {
+	command := []string{
+		"create", "token", sa.ServiceAccountName,
+		"-n", sa.Namespace,
+	}
+	if sa.Token.Duration != 0 {
+		command = append(command, "--duration", sa.Token.Duration.String())
+	}
+	for _, audience := range sa.Token.Audiences {
+		command = append(command, "--audience", audience)
+	}
+
+	o, bserr, err := utils.RunCommand(verbose, "kubectl", c.buildCommand(command, verbose)...)
+	if err != nil {
+		serr := "Token request failed:\n" + bserr.String()
+		return "", serr, err
+	}
+	return strings.TrimSpace(o.String()), "", nil
+}
+
+// Writes the kubeconfig atomically, readable only by the current user
 // Returns full path to file, error string, error
 // Called by CreateKubeconfig
-// TODO: verify permissions
 // TODO: if file exists, prompt for overwrite or new file
 func writeKubeconfigFile(kc string, f string, verbose bool) (string, string, error) {
 
 	// moved to DefineOutputFile
 	// f := filepath.Join(os.Getenv("PWD"), filename)
 
-	if err := ioutil.WriteFile(f, []byte(kc), 0600); err != nil {
+	if err := utils.WriteFileAtomic(f, []byte(kc), 0600); err != nil {
 		return "", "Unable to create kubeconfig file at " + f + ". Check that you have write access to that location.", err
 	}
 
 	return f, "", nil
 }
-
-// Returns server URL, CA, string error, error
-// Called by CreateKubeconfig
-func (c *Cluster) getClusterInfo(verbose bool) (string, string, string, error) {
-
-	kubectlVersion, err := GetKubectlVersion(verbose)
-	if err != nil {
-		return "", "", "Unable to get kubectl version", err
-	}
-	if verbose {
-		fmt.Println(kubectlVersion)
-	}
-
-	path := fmt.Sprintf("{.clusters[?(@.name=='%s')].cluster['server','certificate-authority-data']}", c.Context.ClusterName)
-
-	options := c.buildCommand([]string{
-		"config", "view", "--raw",
-		"-o", "jsonpath=" + path,
-	}, verbose)
-
-	o, bserr, err := utils.RunCommand(verbose, "kubectl", options...)
-	if err != nil {
-		serr := "Get config failed:\n" + bserr.String()
-		return "", "", serr, err
-	}
-	s := o.String()
-	// look for the first space in the output
-	i := strings.Index(s, " ")
-	if len(s) < 5 || i < 3 {
-		return "", "", "Need more info", errors.New("Unexpected return format for cluster properties")
-	}
-
-	// cluster server info is before the first space, denoted by i
-	srv := s[0:i]
-	var cb string
-
-	minorVersion, err := kubectlVersion.ClientVersion.GetMinorVersionInt()
-	if err != nil {
-		return "", "", "Unable to get minor version", err
-	}
-
-	switch {
-	case minorVersion < 12:
-		b := []byte{}
-		// kubectl < 1.12 outputs certificate-authority-data as a byte array
-		// here, we're looping over every pair and converting it to our own byte
-		// array so we can use it
-		for _, cb := range strings.Split(string((o.String())[i+2:len(o.String())-1]), " ") {
-			a, err := strconv.ParseInt(cb, 10, 64)
-			if err != nil {
-				return "", "", "", err
-			}
-			b = append(b, byte(a))
-		}
-		cb = base64.StdEncoding.EncodeToString(b)
-	default:
-		// grab everything after the first space in the output
-		cb = s[i:]
-	}
-
-	return srv, string(cb), "", nil
-}
-
-// Validates a kubeconfig file
-// Called by CreateKubeconfig
-func checkKubeConfigConnectivity(f string, verbose bool) error {
-	_, err := exec.Command("kubectl", "--kubeconfig", f, "get", "pods").Output()
-	return err
-}
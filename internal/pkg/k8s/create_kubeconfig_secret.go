@@ -0,0 +1,86 @@
+package k8s
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"strings"
+
+	"github.com/armory/spinnaker-tools/internal/pkg/diagnostics"
+	"github.com/armory/spinnaker-tools/internal/pkg/utils"
+	"github.com/fatih/color"
+)
+
+// Values for the kubeconfigSecret template
+type kubeconfigSecretData struct {
+	Name      string
+	Namespace string
+	Key       string
+	Data      string
+	// Of the service account the kubeconfig is for (see ownerMetadata)
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+func (data kubeconfigSecretData) metadata() (map[string]string, map[string]string) {
+	return data.Labels, data.Annotations
+}
+
+// StoreKubeconfigSecret : Creates (or updates) an Opaque Secret in the cluster holding a kubeconfig file,
+// so Spinnaker running in this cluster can read it as `encryptedFile:k8s!n:<secretName>!k:<key>`
+// It is labelled and annotated like everything created for the service account
+// The Secret is created / replaced rather than applied, so no copy of the kubeconfig ends up in the
+// last-applied-configuration annotation
+// Returns error string, error
+func (c *Cluster) StoreKubeconfigSecret(ctx diagnostics.Handler, sa ServiceAccount, filename string, namespace string, secretName string, key string, verbose bool) (string, error) {
+	kc, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "Unable to read kubeconfig " + filename, err
+	}
+
+	manifest, err := kubeconfigSecret(kubeconfigSecretData{
+		Name:        secretName,
+		Namespace:   namespace,
+		Key:         key,
+		Data:        base64.StdEncoding.EncodeToString(kc),
+		Labels:      sa.ownerLabels(),
+		Annotations: sa.ownerAnnotations(),
+	}, verbose)
+	if err != nil {
+		return "Unable to render kubeconfig secret " + secretName, err
+	}
+
+	options := c.buildCommand([]string{
+		"get", "secret", secretName,
+		"-n", namespace,
+		"--ignore-not-found", "-o", "name",
+	}, verbose)
+	o, bserr, err := utils.RunCommand(verbose, "kubectl", options...)
+	if err != nil {
+		serr := "Unable to check for secret " + secretName + ":\n" + bserr.String()
+		ctx.Error(serr, err)
+		return serr, err
+	}
+
+	action := secretAction(o.String())
+	color.Blue("Storing kubeconfig in secret %s in namespace %s (%s) ...", secretName, namespace, action)
+
+	options = c.buildCommand([]string{
+		action, "-f", "-",
+	}, verbose)
+	err = utils.RunCommandInput(verbose, "kubectl", manifest, options...)
+	if err != nil {
+		serr := "Unable to " + action + " secret " + secretName
+		ctx.Error(serr, err)
+		return serr, err
+	}
+	return "", nil
+}
+
+// Whether to create or replace a Secret, given the output of `kubectl get secret -o name --ignore-not-found`
+// (empty if it doesn't exist)
+func secretAction(existing string) string {
+	if strings.TrimSpace(existing) == "" {
+		return "create"
+	}
+	return "replace"
+}
@@ -0,0 +1,41 @@
+package k8s
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUseTokenRequest(t *testing.T) {
+	assert.False(t, useTokenRequest(21, "spinnaker-token-abcde"))
+	assert.True(t, useTokenRequest(21, ""))
+	assert.True(t, useTokenRequest(24, "spinnaker-token-abcde"))
+	assert.True(t, useTokenRequest(27, ""))
+}
+
+func TestGetMinorVersionInt(t *testing.T) {
+	v := KubectlVersionDetails{Major: "1", Minor: "24+"}
+	minor, err := v.GetMinorVersionInt()
+	assert.Nil(t, err)
+	assert.Equal(t, 24, minor)
+}
+
+func TestCredentialKind(t *testing.T) {
+	jwt := func(payload string) string {
+		return "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".c2lnbmF0dXJl"
+	}
+
+	requested := credential{Token: jwt(`{"sub":"system:serviceaccount:spinnaker:spinnaker","exp":1893456000}`)}
+	assert.Equal(t, "token", requested.kind())
+	assert.Equal(t, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), requested.expiry())
+
+	legacy := credential{Token: jwt(`{"sub":"system:serviceaccount:spinnaker:spinnaker"}`)}
+	assert.Equal(t, "token-secret", legacy.kind())
+	assert.True(t, legacy.expiry().IsZero())
+
+	certificate := credential{ClientCertificate: []byte("not a certificate")}
+	assert.Equal(t, "certificate", certificate.kind())
+	assert.True(t, certificate.expiry().IsZero())
+}
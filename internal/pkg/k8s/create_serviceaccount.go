@@ -9,108 +9,226 @@ import (
 	"github.com/fatih/color"
 )
 
-// CreateServiceAccount : Creates the service account (and namespace, if it doesn't already exist)
-// TODO: Handle non-admin service account
-// TODO: Handle pre-existing service account
+// CreateServiceAccount : Creates the service account (and namespace, if it doesn't already exist), and
+// grants it its role preset cluster-wide, or in each of its target namespaces
+// If the service account already exists, only the objects DiffServiceAccount found to be new or changed
+// are applied, and those left over from earlier runs are deleted
 func (c *Cluster) CreateServiceAccount(ctx diagnostics.Handler, sa *ServiceAccount, verbose bool) (string, error) {
+	if sa.diff == nil {
+		if _, serr, err := c.DiffServiceAccount(sa, verbose); err != nil {
+			return serr, err
+		}
+	}
+	if !sa.diff.Changed() {
+		color.Green("Service account %s in namespace %s is up to date", sa.ServiceAccountName, sa.Namespace)
+		return "", nil
+	}
+
+	color.Blue("Applying to context %s:", c.Context.ContextName)
+
 	if sa.newNamespace {
 		fmt.Println("Creating namespace", sa.Namespace)
-		err := c.createNamespace(ctx, sa.Namespace, verbose)
+		err := c.createNamespace(ctx, *sa, sa.Namespace, verbose)
 		if err != nil {
 			color.Red("Unable to create namespace")
 			return "Unable to create namespace", err
 		}
 	}
+	for _, target := range sa.newTargetNamespaces {
+		fmt.Println("Creating target namespace", target)
+		err := c.createNamespace(ctx, *sa, target, verbose)
+		if err != nil {
+			return "Unable to create target namespace " + target, err
+		}
+	}
 
 	color.Blue("Creating service account %s ...", sa.ServiceAccountName)
-	err := c.createServiceAccount(*sa, verbose)
+	applied, err := c.createServiceAccount(*sa, verbose)
 	if err != nil {
 		// color.Red("Unable to create service account.")
 		// ctx.Error("Unable to create service account", err)
 		return "Unable to create service account", err
 	}
-	color.Green("Created ServiceAccount %s in namespace %s", sa.ServiceAccountName, sa.Namespace)
+	reportApplied(applied, "Created ServiceAccount %s in namespace %s", sa.ServiceAccountName, sa.Namespace)
 
-	if len(sa.TargetNamespaces) == 0 {
+	if sa.Token.CreateSecret {
+		color.Blue("Creating token secret %s ...", sa.Token.SecretName)
+		applied, serr, err := c.createTokenSecret(*sa, verbose)
+		if err != nil {
+			return serr, err
+		}
+		reportApplied(applied, "Created token Secret %s in namespace %s", sa.Token.SecretName, sa.Namespace)
+	}
+
+	if !sa.Namespaced() && (sa.Role == "" || sa.Role == ClusterAdminRole) {
 		color.Blue("Adding cluster-admin binding to service account %s ...", sa.ServiceAccountName)
-		err := c.addAdmin(*sa, verbose)
+		applied, err := c.addAdmin(*sa, verbose)
 		if err != nil {
 			// color.Red("Unable to create service account.")
 			// ctx.Error("Unable to create service account", err)
 			return "Unable to create service account", err
 		}
-		color.Green("Created ClusterRoleBinding %s-%s-admin in namespace %s", sa.Namespace, sa.ServiceAccountName, sa.Namespace)
+		reportApplied(applied, "Created ClusterRoleBinding %s-%s-admin in namespace %s", sa.Namespace, sa.ServiceAccountName, sa.Namespace)
+	} else if !sa.Namespaced() {
+		color.Blue("Adding %s ClusterRole and binding to service account %s ...", sa.Role, sa.ServiceAccountName)
+		applied, err := c.addClusterRole(*sa, verbose)
+		if err != nil {
+			return "Unable to grant " + string(sa.Role) + " access to service account", err
+		}
+		reportApplied(applied, "Created ClusterRole and ClusterRoleBinding %s-%s-%s", sa.Namespace, sa.ServiceAccountName, sa.Role)
 	} else {
+		if sa.NamespaceBinding == SharedClusterRole {
+			_, roleName := sa.NamespaceRoleRef()
+			color.Blue("Adding %s ClusterRole for target namespaces ...", sa.Role)
+			applied, err := c.addSharedClusterRole(*sa, verbose)
+			if err != nil {
+				return "Unable to create ClusterRole " + roleName, err
+			}
+			reportApplied(applied, "Created ClusterRole %s", roleName)
+		}
 		for _, target := range sa.TargetNamespaces {
 			color.Blue("Granting %s access to namespace %s", sa.ServiceAccountName, target)
-			err := c.addTargetNamespace(*sa, target, verbose)
+			applied, err := c.addTargetNamespace(*sa, target, verbose)
 			if err != nil {
 				// color.Red("Unable to create service account.")
 				// ctx.Error("Unable to create service account", err)
 				return "Unable to grant access to namespace " + target, err
 			}
-			color.Green("Granted %s full access to namespace %s", sa.Namespace, target)
+			access := string(sa.Role)
+			if sa.NamespaceBinding.BuiltIn() {
+				access = string(sa.NamespaceBinding)
+			}
+			reportApplied(applied, "Granted %s %s access to namespace %s", sa.ServiceAccountName, access, target)
 		}
 	}
+
+	// Only once everything else is in place, so the service account never loses access part way through
+	for _, change := range sa.diff.Changes {
+		if change.Action != DeleteObject {
+			continue
+		}
+		if serr, err := c.deleteObject(change.Object, verbose); err != nil {
+			return serr, err
+		}
+		color.Green("Deleted %s", change.Object)
+	}
 	return "", nil
 }
 
+// Reports a step of CreateServiceAccount, which may have found nothing to change
+func reportApplied(applied bool, format string, a ...interface{}) {
+	if applied {
+		color.Green(format, a...)
+	} else {
+		fmt.Println("Unchanged")
+	}
+}
+
+// Applies the objects in a manifest that are new or changed according to the service account's diff,
+// first deleting those that have to be replaced; objects that are unchanged are left alone, keeping
+// their resourceVersion and provenance annotations
+// Without a diff, everything is applied
+// Returns whether anything was applied, error
+func (c *Cluster) applyChanged(sa ServiceAccount, manifest string, verbose bool) (bool, error) {
+	var replaced []ObjectRef
+	manifest, _, err := filterManifest(manifest, func(o ObjectRef) bool {
+		change, ok := sa.diff.change(o)
+		if change.Action == ReplaceObject {
+			replaced = append(replaced, o)
+		}
+		return !ok || change.Action != UnchangedObject
+	})
+	if err != nil || manifest == "" {
+		return false, err
+	}
+
+	for _, o := range replaced {
+		if _, err := c.deleteObject(o, verbose); err != nil {
+			return false, err
+		}
+	}
+	options := c.buildCommand([]string{
+		"apply", "-f", "-",
+	}, verbose)
+	return true, utils.RunCommandInput(verbose, "kubectl", manifest, options...)
+}
+
 // Create namespace in cluster
 // TODO: remove ctx
 // Called by CreateServiceAccount
-func (c *Cluster) createNamespace(ctx diagnostics.Handler, namespace string, verbose bool) error {
+func (c *Cluster) createNamespace(ctx diagnostics.Handler, sa ServiceAccount, namespace string, verbose bool) error {
+	manifest, err := namespaceDefinition(sa, namespace, verbose)
+	if err != nil {
+		return err
+	}
+
 	options := c.buildCommand([]string{
-		"create",
-		"namespace", namespace,
+		"apply", "-f", "-",
 	}, verbose)
 
-	output, serr, err := utils.RunCommand(verbose, "kubectl", options...)
-	if err != nil {
-		ctx.Error(serr.String(), err)
-		color.Red(serr.String())
+	if err := utils.RunCommandInput(verbose, "kubectl", manifest, options...); err != nil {
+		ctx.Error("Unable to create namespace "+namespace, err)
 		return err
 	}
-
-	color.Green(output.String())
+	color.Green("Created namespace %s", namespace)
 	return nil
 }
 
 // Creates Service Account and ClusterRoleBinding to `cluster-admin`
 // Called by CreateServiceAccount
-func (c *Cluster) createServiceAccount(sa ServiceAccount, verbose bool) error {
-	manifest := serviceAccountDefinition(sa, verbose)
+func (c *Cluster) createServiceAccount(sa ServiceAccount, verbose bool) (bool, error) {
+	manifest, err := serviceAccountDefinition(sa, verbose)
+	if err != nil {
+		return false, err
+	}
 	// fmt.Println(manifest)
 
-	options := c.buildCommand([]string{
-		"apply", "-f", "-",
-	}, verbose)
-
-	return utils.RunCommandInput(verbose, "kubectl", manifest, options...)
+	return c.applyChanged(sa, manifest, verbose)
 	// return nil
 }
 
 // Creates Service Account and ClusterRoleBinding to `cluster-admin`
 // Called by CreateServiceAccount
-func (c *Cluster) addAdmin(sa ServiceAccount, verbose bool) error {
-	manifest := adminClusterRoleBinding(sa, verbose)
+func (c *Cluster) addAdmin(sa ServiceAccount, verbose bool) (bool, error) {
+	manifest, err := adminClusterRoleBinding(sa, verbose)
+	if err != nil {
+		return false, err
+	}
 	// fmt.Println(manifest)
 
-	options := c.buildCommand([]string{
-		"apply", "-f", "-",
-	}, verbose)
-
-	return utils.RunCommandInput(verbose, "kubectl", manifest, options...)
+	return c.applyChanged(sa, manifest, verbose)
 	// return nil
 }
 
-func (c *Cluster) addTargetNamespace(sa ServiceAccount, target string, verbose bool) error {
-	manifest := namespaceRoleBinding(sa, target, verbose)
-	// fmt.Println(manifest)
+// Creates a ClusterRole with the rules of the role preset, and ClusterRoleBinding to it
+// Called by CreateServiceAccount
+func (c *Cluster) addClusterRole(sa ServiceAccount, verbose bool) (bool, error) {
+	manifest, err := presetClusterRole(sa, verbose)
+	if err != nil {
+		return false, err
+	}
 
-	options := c.buildCommand([]string{
-		"apply", "-f", "-",
-	}, verbose)
+	return c.applyChanged(sa, manifest, verbose)
+}
+
+// Creates the ClusterRole bound in each target namespace, when they share one
+// Called by CreateServiceAccount
+func (c *Cluster) addSharedClusterRole(sa ServiceAccount, verbose bool) (bool, error) {
+	manifest, err := sharedClusterRole(sa, verbose)
+	if err != nil {
+		return false, err
+	}
+
+	return c.applyChanged(sa, manifest, verbose)
+}
+
+func (c *Cluster) addTargetNamespace(sa ServiceAccount, target string, verbose bool) (bool, error) {
+	manifest, err := namespaceRoleBinding(sa, target, verbose)
+	if err != nil {
+		return false, err
+	}
+	// fmt.Println(manifest)
 
-	return utils.RunCommandInput(verbose, "kubectl", manifest, options...)
+	return c.applyChanged(sa, manifest, verbose)
 	// return nil
 }
\ No newline at end of file
@@ -0,0 +1,18 @@
+package k8s
+
+// Creates a long-lived service-account-token Secret for the service account
+// The token controller populates it asynchronously; use WaitForToken before reading it
+// Returns whether it was applied, error string, error
+// Called by CreateServiceAccount
+func (c *Cluster) createTokenSecret(sa ServiceAccount, verbose bool) (bool, string, error) {
+	manifest, err := serviceAccountTokenSecret(sa, verbose)
+	if err != nil {
+		return false, "Unable to render token secret " + sa.Token.SecretName, err
+	}
+
+	applied, err := c.applyChanged(sa, manifest, verbose)
+	if err != nil {
+		return false, "Unable to create token secret " + sa.Token.SecretName, err
+	}
+	return applied, "", nil
+}
@@ -3,6 +3,7 @@ package k8s
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"text/tabwriter"
@@ -18,9 +19,15 @@ import (
 // DefineServiceAccount : Populates all fields of ServiceAccount sa, including the following:
 // * If Namespace is not specified, gets the list of namespaces and prompts to select one or use a new one
 // * If ServiceAccountName is not specified, prompts for the service account name
+// * If TargetNamespaceSelector is specified, uses the namespaces matching it as target namespaces
+// * Checks that the target namespaces exist, and prompts, fails or marks them to be created if they don't,
+//   according to MissingNamespaces
+// * If there are target namespaces and NamespaceBinding is not specified, prompts for how to grant access
+//   to them (or uses a Role per namespace, if we can't prompt)
+// * If Role is not specified, prompts for the role preset (or uses cluster-admin, if we can't prompt),
+//   unless target namespaces are bound to a built-in ClusterRole
 //
 // TODO: Be able to pass in values for these at start of execution
-// TODO: Prompt for non-admin service account perms
 func (c *Cluster) DefineServiceAccount(ctx diagnostics.Handler, sa *ServiceAccount, verbose bool) (string, error) {
 
 	color.Blue("Getting namespaces ...")
@@ -44,14 +51,27 @@ func (c *Cluster) DefineServiceAccount(ctx diagnostics.Handler, sa *ServiceAccou
 		}
 	}
 
-	// TODO get a current list of service accounts
-	// c.getServiceAccounts(ctx, sa.namespace)
-	// Generally speaking, creating a service account that already exists should not have a negative effect
+	if sa.TargetNamespaceSelector != "" {
+		color.Blue("Getting namespaces matching %s ...", sa.TargetNamespaceSelector)
+		sa.TargetNamespaces, err = c.getNamespacesBySelector(ctx, sa.TargetNamespaceSelector, verbose)
+		if err != nil {
+			return "Unable to get namespaces matching " + sa.TargetNamespaceSelector, err
+		}
+		if len(sa.TargetNamespaces) == 0 {
+			color.Yellow("No namespaces match %s yet; run sync once they exist", sa.TargetNamespaceSelector)
+		}
+	}
 
-	if sa.ServiceAccountName != "" {
-		// TODO allow prepopulated, handle pre-existence
-		sa.newServiceAccount = true
-	} else {
+	missing := missingNamespaces(sa.TargetNamespaces, namespaceNames, sa.Namespace)
+	if len(missing) != 0 {
+		serr, err := confirmMissingNamespaces(missing, sa.MissingNamespaces, verbose)
+		if err != nil {
+			return serr, err
+		}
+		sa.newTargetNamespaces = missing
+	}
+
+	if sa.ServiceAccountName == "" {
 		serviceAccountPrompt := promptui.Prompt{
 			Label:    "What name would you like to give the service account",
 			Default:  "spinnaker-service-account",
@@ -62,11 +82,143 @@ func (c *Cluster) DefineServiceAccount(ctx diagnostics.Handler, sa *ServiceAccou
 		if err != nil || len(sa.ServiceAccountName) < 2 {
 			return "Service account name not given", err
 		}
-		sa.newServiceAccount = true
+	}
+
+	// Creating a service account that already exists only changes what differs (see DiffServiceAccount)
+	sa.newServiceAccount = true
+	if !sa.newNamespace {
+		_, names, err := c.getServiceAccounts(ctx, sa, verbose)
+		if err != nil {
+			return "Unable to get service accounts in namespace " + sa.Namespace, err
+		}
+		sa.newServiceAccount = !contains(names, sa.ServiceAccountName)
+	}
+	if !sa.newServiceAccount {
+		color.Yellow("Service account %s already exists in namespace %s; only what differs will be changed", sa.ServiceAccountName, sa.Namespace)
+	}
+
+	if sa.Namespaced() && sa.NamespaceBinding == "" {
+		sa.NamespaceBinding, err = promptNamespaceBinding(verbose)
+		if err != nil {
+			return "Namespace binding not selected", err
+		}
+	}
+
+	if sa.Role == "" && !(sa.Namespaced() && sa.NamespaceBinding.BuiltIn()) {
+		sa.Role, sa.AllowSecrets, err = promptRole(sa.AllowSecrets, verbose)
+		if err != nil {
+			return "Role not selected", err
+		}
+	}
+	return "", nil
+}
+
+// Prompt for the role preset to grant the service account, and whether it may access Secrets
+// Without a terminal to prompt on, keeps the historical default of cluster-admin
+// Returns role, whether secrets are allowed, and err
+// Called by DefineServiceAccount
+func promptRole(allowSecrets bool, verbose bool) (Role, bool, error) {
+	if !utils.IsInteractive() {
+		return ClusterAdminRole, allowSecrets, nil
+	}
+
+	rolePrompt := promptui.Select{
+		Label: "What should the service account be allowed to do",
+		Items: Roles,
+		Templates: &promptui.SelectTemplates{
+			Active:   fmt.Sprintf("%s {{ . | underline }} ({{ .Description }})", promptui.IconSelect),
+			Inactive: "{{ . }} ({{ .Description }})",
+			Selected: fmt.Sprintf(`{{ "%s" | green }} {{ . | faint }}`, promptui.IconGood),
+		},
+	}
+	idx, _, err := rolePrompt.Run()
+	if err != nil {
+		return "", false, err
+	}
+	role := Roles[idx]
+
+	if role != ClusterAdminRole && !allowSecrets {
+		secretsPrompt := promptui.Prompt{
+			Label:     "Allow the service account to read and manage Secrets",
+			IsConfirm: true,
+		}
+		_, err := secretsPrompt.Run()
+		if err == promptui.ErrInterrupt {
+			return "", false, err
+		}
+		allowSecrets = err == nil
+	}
+	return role, allowSecrets, nil
+}
+
+// Target namespaces that aren't among the existing ones
+// The service account's own namespace is skipped, since it's created separately when it's new
+// Called by DefineServiceAccount
+func missingNamespaces(targets []string, existing []string, own string) []string {
+	var missing []string
+	for _, target := range targets {
+		found := target == own
+		for _, namespace := range existing {
+			if target == namespace {
+				found = true
+			}
+		}
+		if !found {
+			missing = append(missing, target)
+		}
+	}
+	return missing
+}
+
+// Decides whether missing target namespaces may be created, according to the policy
+// Returns error string, error (nil if they should be created)
+// Called by DefineServiceAccount
+func confirmMissingNamespaces(missing []string, policy MissingNamespacePolicy, verbose bool) (string, error) {
+	list := strings.Join(missing, ", ")
+	switch policy {
+	case CreateMissingNamespaces:
+		color.Yellow("Target namespaces %s do not exist, and will be created", list)
+		return "", nil
+	case FailMissingNamespaces:
+		return "Target namespaces " + list + " do not exist (use --create-missing-namespaces create to create them)", errors.New("target namespaces not found")
+	}
+
+	if !utils.IsInteractive() {
+		return "Target namespaces " + list + " do not exist, and there is no terminal to confirm creating them (use --create-missing-namespaces create)", errors.New("target namespaces not found")
+	}
+	createPrompt := promptui.Prompt{
+		Label:     "Target namespaces " + list + " do not exist. Create them",
+		IsConfirm: true,
+	}
+	if _, err := createPrompt.Run(); err != nil {
+		return "Not creating target namespaces " + list, errors.New("target namespaces not found")
 	}
 	return "", nil
 }
 
+// Prompt for how to grant the service account access to its target namespaces
+// Without a terminal to prompt on, keeps the historical default of a Role per namespace
+// Called by DefineServiceAccount
+func promptNamespaceBinding(verbose bool) (NamespaceBinding, error) {
+	if !utils.IsInteractive() {
+		return RolePerNamespace, nil
+	}
+
+	bindingPrompt := promptui.Select{
+		Label: "How should the service account be granted access to the target namespaces",
+		Items: NamespaceBindings,
+		Templates: &promptui.SelectTemplates{
+			Active:   fmt.Sprintf("%s {{ . | underline }} ({{ .Description }})", promptui.IconSelect),
+			Inactive: "{{ . }} ({{ .Description }})",
+			Selected: fmt.Sprintf(`{{ "%s" | green }} {{ . | faint }}`, promptui.IconGood),
+		},
+	}
+	idx, _, err := bindingPrompt.Run()
+	if err != nil {
+		return "", err
+	}
+	return NamespaceBindings[idx], nil
+}
 
 // Gets the current list of namespaces from the cluster
 // Returns two items:
@@ -112,6 +264,39 @@ func (c *Cluster) getNamespaces(ctx diagnostics.Handler, verbose bool) ([]string
 	return strings.Split(b.String(), "\n"), names, nil
 }
 
+// Gets the names of the active namespaces matching a label selector
+// Namespaces being deleted are skipped, since nothing can be created in them
+// Called by DefineServiceAccount and SyncServiceAccount
+func (c *Cluster) getNamespacesBySelector(ctx diagnostics.Handler, selector string, verbose bool) ([]string, error) {
+	options := c.buildCommand([]string{
+		"get", "namespace",
+		"-l", selector,
+		"-o=json",
+	}, verbose)
+
+	output, serr, err := utils.RunCommand(verbose, "kubectl", options...)
+	if err != nil {
+		ctx.Error(serr.String(), err)
+		color.Red(serr.String())
+		return nil, err
+	}
+
+	var n namespaceJSON
+	if err := json.NewDecoder(output).Decode(&n); err != nil {
+		ctx.Error("Cannot decode JSON for getting namespaces", err)
+		return nil, err
+	}
+
+	var names []string
+	for _, item := range n.Items {
+		if item.Status.Phase == "Terminating" {
+			continue
+		}
+		names = append(names, item.Metadata.Name)
+	}
+	return names, nil
+}
+
 // Prompt for the namespace to use, given list of namespaces (long names and short names)
 // Returns namespace, whether it's a 'new' namespace, and err
 // Called by DefineServiceAccount
@@ -0,0 +1,25 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMissingNamespaces(t *testing.T) {
+	existing := []string{"default", "kube-system", "apps"}
+	assert.Nil(t, missingNamespaces([]string{"apps", "default"}, existing, "spinnaker"))
+	assert.Equal(t, []string{"aps"}, missingNamespaces([]string{"aps", "default"}, existing, "spinnaker"))
+
+	// The service account's own namespace is created separately
+	assert.Nil(t, missingNamespaces([]string{"spinnaker"}, existing, "spinnaker"))
+}
+
+func TestConfirmMissingNamespaces(t *testing.T) {
+	_, err := confirmMissingNamespaces([]string{"aps"}, CreateMissingNamespaces, false)
+	assert.Nil(t, err)
+
+	serr, err := confirmMissingNamespaces([]string{"aps"}, FailMissingNamespaces, false)
+	assert.NotNil(t, err)
+	assert.Contains(t, serr, "aps")
+}
@@ -0,0 +1,329 @@
+package k8s
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/armory/spinnaker-tools/internal/pkg/diagnostics"
+	"github.com/armory/spinnaker-tools/internal/pkg/utils"
+	"github.com/fatih/color"
+	"github.com/manifoldco/promptui"
+)
+
+// ObjectRef : A Kubernetes object; Namespace is empty for cluster-scoped objects
+type ObjectRef struct {
+	Kind      string `json:"kind" yaml:"kind"`
+	Namespace string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Name      string `json:"name" yaml:"name"`
+}
+
+func (o ObjectRef) String() string {
+	if o.Namespace == "" {
+		return o.Kind + " " + o.Name
+	}
+	return o.Kind + " " + o.Namespace + "/" + o.Name
+}
+
+// ServiceAccountObjects : What CreateServiceAccount created for a service account, as found by
+// FindServiceAccountObjects
+type ServiceAccountObjects struct {
+	// In the order they are deleted: bindings before what they grant, and the ServiceAccount last
+	Objects []ObjectRef
+	// Namespaces created for the service account, only deleted when asked to
+	CreatedNamespaces []string
+	// Namespaces the objects are in that weren't created for the service account; never deleted
+	OtherNamespaces []string
+}
+
+// FindServiceAccountObjects : Finds what CreateServiceAccount created for the service account:
+//   - RoleBindings (named <namespace>-<service account>-binding) for it in any namespace, and the
+//     -local-admin Roles or shared ClusterRole they bind
+//   - ClusterRoleBindings (<namespace>-<service account>-admin, or -<role preset>) for it, and the
+//     role preset ClusterRoles they bind; bindings with other names that share the prefix are not its
+//   - Token Secrets for it, and the ServiceAccount itself
+//   - Namespaces created for it (see CreatedForAnnotation)
+//   - RoleBindings and ClusterRoleBindings with other names labelled for it by adopt; the roles they
+//     bind were not created for it, and are left alone (even if named like the binding), unless they
+//     are labelled for it too
+//
+// Bindings are only included if they bind the service account, so objects with the same names
+// created by anything else are left alone
+// Returns objects, error string, error
+func (c *Cluster) FindServiceAccountObjects(sa ServiceAccount, verbose bool) (ServiceAccountObjects, string, error) {
+	objs, serr, err := c.findServiceAccountObjects(sa, verbose)
+	if err != nil {
+		return objs, serr, err
+	}
+	if len(objs.Objects) == 0 && len(objs.CreatedNamespaces) == 0 {
+		return objs, "Found nothing created for service account " + sa.ServiceAccountName + " in namespace " + sa.Namespace, errors.New("service account not found")
+	}
+	return objs, "", nil
+}
+
+// Finds the objects for FindServiceAccountObjects, which may be none
+// Called by FindServiceAccountObjects and DiffServiceAccount
+func (c *Cluster) findServiceAccountObjects(sa ServiceAccount, verbose bool) (ServiceAccountObjects, string, error) {
+	var objs ServiceAccountObjects
+	namespaces := map[string]bool{sa.Namespace: true}
+
+	// By name, and (for those taken over by adopt) by label
+	var roleBindings, labelledRoleBindings roleBindingsJSON
+	serr, err := c.getJSON([]string{
+		"get", "rolebindings", "--all-namespaces",
+		"--field-selector", "metadata.name=" + sa.NamespaceRoleBindingName(),
+	}, &roleBindings, verbose)
+	if err == nil {
+		serr, err = c.getJSON([]string{
+			"get", "rolebindings", "--all-namespaces", "-l", sa.OwnerSelector(),
+		}, &labelledRoleBindings, verbose)
+	}
+	if err != nil {
+		return objs, "Unable to get RoleBindings:\n" + serr, err
+	}
+	var clusterRoleBindings roleBindingsJSON
+	serr, err = c.getJSON([]string{"get", "clusterrolebindings"}, &clusterRoleBindings, verbose)
+	if err != nil {
+		return objs, "Unable to get ClusterRoleBindings:\n" + serr, err
+	}
+	var labelledClusterRoles objectsJSON
+	serr, err = c.getJSON([]string{"get", "clusterroles", "-l", sa.OwnerSelector()}, &labelledClusterRoles, verbose)
+	if err != nil {
+		return objs, "Unable to get ClusterRoles:\n" + serr, err
+	}
+	var clusterRoles []string
+	for _, item := range labelledClusterRoles.Items {
+		clusterRoles = append(clusterRoles, item.Metadata.Name)
+	}
+	var targets []string
+	objs.Objects, targets = sa.OwnedRBAC(append(roleBindings.Items, labelledRoleBindings.Items...), clusterRoleBindings.Items, clusterRoles)
+	for _, target := range targets {
+		namespaces[target] = true
+	}
+
+	var secrets objectsJSON
+	serr, err = c.getJSON([]string{
+		"get", "secrets", "-n", sa.Namespace,
+		"--field-selector", "type=kubernetes.io/service-account-token",
+	}, &secrets, verbose)
+	if err != nil {
+		return objs, "Unable to get token Secrets:\n" + serr, err
+	}
+	for _, item := range secrets.Items {
+		if item.Metadata.Annotations["kubernetes.io/service-account.name"] == sa.ServiceAccountName {
+			objs.Objects = append(objs.Objects, ObjectRef{"Secret", sa.Namespace, item.Metadata.Name})
+		}
+	}
+
+	var serviceAccounts objectsJSON
+	serr, err = c.getJSON([]string{
+		"get", "serviceaccounts", "-n", sa.Namespace,
+		"--field-selector", "metadata.name=" + sa.ServiceAccountName,
+	}, &serviceAccounts, verbose)
+	if err != nil {
+		return objs, "Unable to get service account:\n" + serr, err
+	}
+	if len(serviceAccounts.Items) != 0 {
+		objs.Objects = append(objs.Objects, ObjectRef{"ServiceAccount", sa.Namespace, sa.ServiceAccountName})
+	}
+
+	var managedNamespaces objectsJSON
+	serr, err = c.getJSON([]string{
+		"get", "namespaces", "-l", ManagedByLabel + "=" + ManagedByValue,
+	}, &managedNamespaces, verbose)
+	if err != nil {
+		return objs, "Unable to get namespaces:\n" + serr, err
+	}
+	for _, item := range managedNamespaces.Items {
+		if item.Metadata.Annotations[CreatedForAnnotation] == sa.Namespace+"/"+sa.ServiceAccountName {
+			objs.CreatedNamespaces = append(objs.CreatedNamespaces, item.Metadata.Name)
+		}
+	}
+	for namespace := range namespaces {
+		if !contains(objs.CreatedNamespaces, namespace) {
+			objs.OtherNamespaces = append(objs.OtherNamespaces, namespace)
+		}
+	}
+	sort.Strings(objs.CreatedNamespaces)
+	sort.Strings(objs.OtherNamespaces)
+	return objs, "", nil
+}
+
+// OwnedRBAC : Picks out the RoleBindings and ClusterRoleBindings created (or adopted) for the service
+// account, and the Roles and ClusterRoles created for it that they bind, as FindServiceAccountObjects
+// does; for callers that list them from the API server themselves, such as the controller
+//   - RoleBindings are picked if they are named NamespaceRoleBindingName, or labelled for the service
+//     account (see OwnerSelector)
+//   - ClusterRoleBindings are picked if they have one of the names CreateServiceAccount gives them, or
+//     are labelled for the service account, so others that happen to share the prefix are left alone
+//   - The ClusterRole a ClusterRoleBinding binds is only picked if the binding has one of those names,
+//     or the ClusterRole is in labelledClusterRoles (those labelled for the service account), so the
+//     roles of adopted bindings are left alone
+//
+// Bindings are only picked if they bind the service account
+// Returns the objects, bindings first, and the namespaces of the RoleBindings
+func (sa ServiceAccount) OwnedRBAC(roleBindings []RoleBindingJSON, clusterRoleBindings []RoleBindingJSON, labelledClusterRoles []string) ([]ObjectRef, []string) {
+	var objects, roles, clusterRoles []ObjectRef
+	var namespaces []string
+	localRole := ObjectRef{"Role", "", sa.Namespace + "-" + sa.ServiceAccountName + "-local-admin"}
+	sharedRole := ObjectRef{"ClusterRole", "", sa.Namespace + "-" + sa.ServiceAccountName + "-shared"}
+	for _, item := range roleBindings {
+		binding := ObjectRef{"RoleBinding", item.Metadata.Namespace, item.Metadata.Name}
+		owned := item.Metadata.Name == sa.NamespaceRoleBindingName() || sa.ownsLabels(item.Metadata.Labels)
+		if !owned || !bindsServiceAccount(item.Subjects, sa) || containsObject(objects, binding) {
+			continue
+		}
+		objects = append(objects, binding)
+		target := item.Metadata.Namespace
+		if !contains(namespaces, target) {
+			namespaces = append(namespaces, target)
+		}
+		switch (ObjectRef{item.RoleRef.Kind, "", item.RoleRef.Name}) {
+		case localRole:
+			roles = append(roles, ObjectRef{"Role", target, localRole.Name})
+		case sharedRole:
+			clusterRoles = appendObject(clusterRoles, sharedRole)
+		}
+	}
+
+	names := sa.clusterRoleBindingNames()
+	for _, item := range clusterRoleBindings {
+		name := item.Metadata.Name
+		if !(contains(names, name) || sa.ownsLabels(item.Metadata.Labels)) || !bindsServiceAccount(item.Subjects, sa) {
+			continue
+		}
+		objects = append(objects, ObjectRef{"ClusterRoleBinding", "", name})
+		if item.RoleRef.Kind != "ClusterRole" {
+			continue
+		}
+		// Role presets are granted cluster-wide through a ClusterRole named like its binding
+		if (contains(names, name) && item.RoleRef.Name == name) || contains(labelledClusterRoles, item.RoleRef.Name) {
+			clusterRoles = appendObject(clusterRoles, ObjectRef{"ClusterRole", "", item.RoleRef.Name})
+		}
+	}
+	return append(append(objects, roles...), clusterRoles...), namespaces
+}
+
+// DeletionSummary : Describes what DeleteServiceAccount deletes, and keeps, one line each
+func DeletionSummary(objs ServiceAccountObjects, deleteNamespaces bool) []string {
+	var lines []string
+	for _, o := range objs.Objects {
+		lines = append(lines, "delete "+o.String())
+	}
+	for _, namespace := range objs.CreatedNamespaces {
+		if deleteNamespaces {
+			lines = append(lines, "delete Namespace "+namespace+" (created for the service account), and everything in it")
+		} else {
+			lines = append(lines, "keep   Namespace "+namespace+" (created for the service account; use --delete-namespaces to delete it)")
+		}
+	}
+	for _, namespace := range objs.OtherNamespaces {
+		lines = append(lines, "keep   Namespace "+namespace+" (not created for the service account)")
+	}
+	return lines
+}
+
+// ConfirmDeletion : Asks whether to go ahead with the deletion, unless told to already
+// Returns error string, error
+func ConfirmDeletion(assumeYes bool) (string, error) {
+	if assumeYes {
+		return "", nil
+	}
+	if !utils.IsInteractive() {
+		return "There is no terminal to confirm deleting on (use --yes)", errors.New("deletion not confirmed")
+	}
+	confirmPrompt := promptui.Prompt{
+		Label:     "Delete these",
+		IsConfirm: true,
+	}
+	if _, err := confirmPrompt.Run(); err != nil {
+		return "Not deleting anything", errors.New("deletion not confirmed")
+	}
+	return "", nil
+}
+
+// DeleteServiceAccount : Deletes the objects found by FindServiceAccountObjects, and (if deleteNamespaces)
+// the namespaces created for the service account
+// Namespaces are deleted last, so nothing else is left half deleted if one of them fails
+// Returns error string, error
+func (c *Cluster) DeleteServiceAccount(ctx diagnostics.Handler, objs ServiceAccountObjects, deleteNamespaces bool, verbose bool) (string, error) {
+	toDelete := append([]ObjectRef{}, objs.Objects...)
+	if deleteNamespaces {
+		for _, namespace := range objs.CreatedNamespaces {
+			toDelete = append(toDelete, ObjectRef{"Namespace", "", namespace})
+		}
+	}
+
+	for _, o := range toDelete {
+		if serr, err := c.deleteObject(o, verbose); err != nil {
+			ctx.Error(serr, err)
+			return serr, err
+		}
+		color.Green("Deleted %s", o)
+	}
+	return "", nil
+}
+
+// Deletes an object, if it still exists
+// Returns error string, error
+func (c *Cluster) deleteObject(o ObjectRef, verbose bool) (string, error) {
+	command := []string{"delete", strings.ToLower(o.Kind), o.Name, "--ignore-not-found"}
+	if o.Namespace != "" {
+		command = append(command, "-n", o.Namespace)
+	}
+	_, bserr, err := utils.RunCommand(verbose, "kubectl", c.buildCommand(command, verbose)...)
+	if err != nil {
+		return "Unable to delete " + o.String() + ":\n" + bserr.String(), err
+	}
+	return "", nil
+}
+
+// Runs a kubectl get command with JSON output, and decodes it into v
+// Returns error string, error
+func (c *Cluster) getJSON(command []string, v interface{}, verbose bool) (string, error) {
+	options := c.buildCommand(append(command, "-o=json"), verbose)
+	o, bserr, err := utils.RunCommand(verbose, "kubectl", options...)
+	if err != nil {
+		return bserr.String(), err
+	}
+	if err := json.NewDecoder(o).Decode(v); err != nil {
+		return "Cannot decode JSON", err
+	}
+	return "", nil
+}
+
+// Whether the subjects of a binding include the service account
+func bindsServiceAccount(subjects []subjectJSON, sa ServiceAccount) bool {
+	for _, subject := range subjects {
+		if subject.Kind == "ServiceAccount" && subject.Name == sa.ServiceAccountName && subject.Namespace == sa.Namespace {
+			return true
+		}
+	}
+	return false
+}
+
+func appendObject(objects []ObjectRef, o ObjectRef) []ObjectRef {
+	if containsObject(objects, o) {
+		return objects
+	}
+	return append(objects, o)
+}
+
+func containsObject(objects []ObjectRef, o ObjectRef) bool {
+	for _, existing := range objects {
+		if existing == o {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
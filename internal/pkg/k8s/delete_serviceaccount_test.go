@@ -0,0 +1,95 @@
+package k8s
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeletionSummary(t *testing.T) {
+	objs := ServiceAccountObjects{
+		Objects: []ObjectRef{
+			{"RoleBinding", "apps", "spinnaker-deployer-binding"},
+			{"Role", "apps", "spinnaker-deployer-local-admin"},
+			{"ServiceAccount", "spinnaker", "deployer"},
+		},
+		CreatedNamespaces: []string{"apps"},
+		OtherNamespaces:   []string{"spinnaker"},
+	}
+	assert.Equal(t, []string{
+		"delete RoleBinding apps/spinnaker-deployer-binding",
+		"delete Role apps/spinnaker-deployer-local-admin",
+		"delete ServiceAccount spinnaker/deployer",
+		"keep   Namespace apps (created for the service account; use --delete-namespaces to delete it)",
+		"keep   Namespace spinnaker (not created for the service account)",
+	}, DeletionSummary(objs, false))
+	assert.Contains(t, DeletionSummary(objs, true), "delete Namespace apps (created for the service account), and everything in it")
+}
+
+func TestBindsServiceAccount(t *testing.T) {
+	sa := ServiceAccount{Namespace: "spinnaker", ServiceAccountName: "deployer"}
+	assert.True(t, bindsServiceAccount([]subjectJSON{
+		{Kind: "User", Name: "deployer"},
+		{Kind: "ServiceAccount", Name: "deployer", Namespace: "spinnaker"},
+	}, sa))
+	// Same name, another namespace
+	assert.False(t, bindsServiceAccount([]subjectJSON{{Kind: "ServiceAccount", Name: "deployer", Namespace: "default"}}, sa))
+	assert.False(t, bindsServiceAccount(nil, sa))
+}
+
+func TestNamespaceDefinitionRecordsServiceAccount(t *testing.T) {
+	sa := ServiceAccount{Namespace: "spinnaker", ServiceAccountName: "deployer"}
+	manifest, err := namespaceDefinition(sa, "apps", false)
+	assert.Nil(t, err)
+	assert.Contains(t, manifest, "    "+ManagedByLabel+": \""+ManagedByValue+"\"\n")
+	assert.Contains(t, manifest, "    "+CreatedForAnnotation+": \"spinnaker/deployer\"\n")
+}
+
+func TestOwnedRBACExactNames(t *testing.T) {
+	sa := ServiceAccount{Namespace: "default", ServiceAccountName: "spinnaker"}
+	var roleBindings, clusterRoleBindings roleBindingsJSON
+	assert.Nil(t, json.Unmarshal([]byte(`{"items": [
+		{"metadata": {"name": "default-spinnaker-binding", "namespace": "apps"}, "roleRef": {"kind": "Role", "name": "default-spinnaker-local-admin"},
+		 "subjects": [{"kind": "ServiceAccount", "name": "spinnaker", "namespace": "default"}]},
+		{"metadata": {"name": "default-spinnaker-binding", "namespace": "team"}, "roleRef": {"kind": "Role", "name": "default-spinnaker-local-admin"},
+		 "subjects": [{"kind": "ServiceAccount", "name": "spinnaker", "namespace": "team"}]}
+	]}`), &roleBindings))
+	assert.Nil(t, json.Unmarshal([]byte(`{"items": [
+		{"metadata": {"name": "default-spinnaker-spinnaker-deployer"}, "roleRef": {"kind": "ClusterRole", "name": "default-spinnaker-spinnaker-deployer"},
+		 "subjects": [{"kind": "ServiceAccount", "name": "spinnaker", "namespace": "default"}]},
+		{"metadata": {"name": "default-spinnaker-monitoring"}, "roleRef": {"kind": "ClusterRole", "name": "default-spinnaker-monitoring"},
+		 "subjects": [{"kind": "ServiceAccount", "name": "spinnaker", "namespace": "default"}]}
+	]}`), &clusterRoleBindings))
+
+	objects, namespaces := sa.OwnedRBAC(roleBindings.Items, clusterRoleBindings.Items, nil)
+	// default-spinnaker-monitoring only shares the prefix, so neither it nor its ClusterRole is included,
+	// and the binding in team has the same name but binds another service account
+	assert.Equal(t, []ObjectRef{
+		{"RoleBinding", "apps", "default-spinnaker-binding"},
+		{"ClusterRoleBinding", "", "default-spinnaker-spinnaker-deployer"},
+		{"Role", "apps", "default-spinnaker-local-admin"},
+		{"ClusterRole", "", "default-spinnaker-spinnaker-deployer"},
+	}, objects)
+	assert.Equal(t, []string{"apps"}, namespaces)
+}
+
+func TestOwnedRBACAdopted(t *testing.T) {
+	sa := ServiceAccount{Namespace: "spinnaker", ServiceAccountName: "deployer"}
+	var clusterRoleBindings roleBindingsJSON
+	assert.Nil(t, json.Unmarshal([]byte(`{"items": [
+		{"metadata": {"name": "deployer", "labels": {
+			"spinnaker-tools.armory.io/service-account-namespace": "spinnaker",
+			"spinnaker-tools.armory.io/service-account-name": "deployer"}},
+		 "roleRef": {"kind": "ClusterRole", "name": "deployer"},
+		 "subjects": [{"kind": "ServiceAccount", "name": "deployer", "namespace": "spinnaker"}]}
+	]}`), &clusterRoleBindings))
+
+	// The adopted binding goes, but the hand-made ClusterRole named like it stays
+	objects, _ := sa.OwnedRBAC(nil, clusterRoleBindings.Items, nil)
+	assert.Equal(t, []ObjectRef{{"ClusterRoleBinding", "", "deployer"}}, objects)
+
+	// Unless it is labelled for the service account too
+	objects, _ = sa.OwnedRBAC(nil, clusterRoleBindings.Items, []string{"deployer"})
+	assert.Equal(t, []ObjectRef{{"ClusterRoleBinding", "", "deployer"}, {"ClusterRole", "", "deployer"}}, objects)
+}
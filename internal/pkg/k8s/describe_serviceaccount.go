@@ -0,0 +1,341 @@
+package k8s
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/armory/spinnaker-tools/internal/pkg/utils"
+	"github.com/fatih/color"
+)
+
+// ServiceAccountDescription : What a service account can do, as found by DescribeServiceAccount
+type ServiceAccountDescription struct {
+	Context   string    `json:"context" yaml:"context"`
+	Namespace string    `json:"namespace" yaml:"namespace"`
+	Name      string    `json:"name" yaml:"name"`
+	Bindings  []Binding `json:"bindings" yaml:"bindings"`
+	// Roles bound that don't exist, so grant nothing
+	MissingRoles []ObjectRef `json:"missingRoles,omitempty" yaml:"missingRoles,omitempty"`
+	// Cluster-wide first (granted by ClusterRoleBindings, so in every namespace), then each namespace
+	// the service account has RoleBindings in
+	Permissions []PermissionMatrix `json:"permissions" yaml:"permissions"`
+	// Whether the permissions were cross-checked with SelfSubjectRulesReview, and if not, why not
+	Reviewed    bool   `json:"reviewed" yaml:"reviewed"`
+	ReviewError string `json:"reviewError,omitempty" yaml:"reviewError,omitempty"`
+}
+
+// PermissionMatrix : The verbs allowed on each resource, in a namespace or cluster-wide
+type PermissionMatrix struct {
+	// Empty for cluster-wide
+	Namespace string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	// The columns: the usual verbs, and any others the rules allow
+	Verbs           []string              `json:"verbs" yaml:"verbs"`
+	Resources       []ResourcePermissions `json:"resources" yaml:"resources"`
+	NonResourceURLs []ResourcePermissions `json:"nonResourceURLs,omitempty" yaml:"nonResourceURLs,omitempty"`
+	// Where the rules from the bindings and those from SelfSubjectRulesReview disagree
+	Mismatches []string `json:"mismatches,omitempty" yaml:"mismatches,omitempty"`
+	// Whether SelfSubjectRulesReview said its rules may be incomplete (as with webhook authorizers)
+	ReviewIncomplete bool `json:"reviewIncomplete,omitempty" yaml:"reviewIncomplete,omitempty"`
+}
+
+// ResourcePermissions : A row of a PermissionMatrix
+type ResourcePermissions struct {
+	// resource.group (just resource for the core group), or a non-resource URL
+	Resource string `json:"resource" yaml:"resource"`
+	// By verb: AllAccess, or SomeAccess if only for some resource names
+	Verbs map[string]string `json:"verbs" yaml:"verbs"`
+}
+
+const (
+	// AllAccess : The verb is allowed on every object of the resource
+	AllAccess = "yes"
+	// SomeAccess : The verb is only allowed on objects with the resource names listed in the rules
+	SomeAccess = "some"
+)
+
+// DescribeServiceAccount : Works out what a service account can do, by doing the following:
+//   - Finds every binding that references it, directly or through the groups it is in (see FindBindings)
+//   - Reads the rules of the Roles and ClusterRoles they bind
+//   - Builds a permission matrix cluster-wide, and for each namespace it has RoleBindings in (or, with
+//     none, its own namespace), including what is granted cluster-wide
+//   - Cross-checks each namespace with a SelfSubjectRulesReview, made as the service account with a
+//     short-lived token, which reports what the API server actually allows it
+//
+// Failing to get a token only skips the cross-check
+// Returns description, error string, error
+func (c *Cluster) DescribeServiceAccount(sa ServiceAccount, verbose bool) (ServiceAccountDescription, string, error) {
+	d := ServiceAccountDescription{
+		Context:   c.Context.ContextName,
+		Namespace: sa.Namespace,
+		Name:      sa.ServiceAccountName,
+	}
+	var serr string
+	var err error
+	d.Bindings, serr, err = c.FindBindings(sa, true, verbose)
+	if err != nil {
+		return d, serr, err
+	}
+
+	var clusterRules []PolicyRule
+	namespaceRules := map[string][]PolicyRule{}
+	roleRules := map[ObjectRef][]PolicyRule{}
+	for _, b := range d.Bindings {
+		rules, ok := roleRules[b.Role]
+		if !ok {
+			rules, serr, err = c.getRoleRules(b.Role, verbose)
+			if err != nil {
+				return d, serr, err
+			}
+			if rules == nil {
+				d.MissingRoles = appendObject(d.MissingRoles, b.Role)
+			}
+			roleRules[b.Role] = rules
+		}
+		if b.Object.Kind == "ClusterRoleBinding" {
+			clusterRules = append(clusterRules, rules...)
+		} else {
+			namespaceRules[b.Object.Namespace] = append(namespaceRules[b.Object.Namespace], rules...)
+		}
+	}
+
+	namespaces := []string{sa.Namespace}
+	if len(namespaceRules) != 0 {
+		namespaces = nil
+		for namespace := range namespaceRules {
+			namespaces = append(namespaces, namespace)
+		}
+		sort.Strings(namespaces)
+	}
+
+	d.Permissions = append(d.Permissions, newPermissionMatrix("", clusterRules))
+	reviewer, cleanup, serr, err := c.asServiceAccount(sa, verbose)
+	defer cleanup()
+	if err != nil {
+		d.ReviewError = strings.TrimSpace(serr + " " + err.Error())
+		color.Yellow("Not cross-checking with SelfSubjectRulesReview: %s", d.ReviewError)
+	}
+	d.Reviewed = err == nil
+
+	for _, namespace := range namespaces {
+		granted := append(append([]PolicyRule{}, clusterRules...), namespaceRules[namespace]...)
+		m := newPermissionMatrix(namespace, granted)
+		if d.Reviewed {
+			reviewed, incomplete, serr, err := reviewer.reviewRules(namespace, verbose)
+			if err != nil {
+				return d, serr, err
+			}
+			m.Mismatches = ruleMismatches(granted, reviewed)
+			m.ReviewIncomplete = incomplete
+		}
+		d.Permissions = append(d.Permissions, m)
+	}
+	return d, "", nil
+}
+
+// Gets the rules of a Role or ClusterRole; nil if it doesn't exist
+// Returns rules, error string, error
+func (c *Cluster) getRoleRules(role ObjectRef, verbose bool) ([]PolicyRule, string, error) {
+	live, serr, err := c.getObject(role, verbose)
+	if err != nil || live == nil {
+		return nil, serr, err
+	}
+	b, err := json.Marshal(live["rules"])
+	if err != nil {
+		return nil, "Cannot encode rules of " + role.String(), err
+	}
+	rules := []PolicyRule{}
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return nil, "Cannot decode rules of " + role.String(), err
+	}
+	return rules, "", nil
+}
+
+// A Cluster that authenticates as the service account, through a temporary kubeconfig holding a
+// short-lived token for it, like the one CreateKubeconfig writes
+// The cleanup function removes the kubeconfig, and is safe to call even on error
+// Returns cluster, cleanup, error string, error
+func (c *Cluster) asServiceAccount(sa ServiceAccount, verbose bool) (Cluster, func(), string, error) {
+	cleanup := func() {}
+	// The shortest lifetime the API server allows
+	sa.Token.Duration = 10 * time.Minute
+	token, serr, err := c.getToken(sa, verbose)
+	if err != nil {
+		return Cluster{}, cleanup, "Unable to get a token for the service account: " + serr, err
+	}
+
+	source, err := loadKubeconfig(c.KubeconfigFile)
+	if err != nil {
+		return Cluster{}, cleanup, "Unable to load kubeconfig " + c.KubeconfigFile, err
+	}
+	kc, err := source.extract(c.Context.ContextName, KubeconfigContext(sa), sa.Namespace, credential{Token: token}, filepath.Dir(c.KubeconfigFile))
+	if err != nil {
+		return Cluster{}, cleanup, "Unable to build kubeconfig for context " + c.Context.ContextName, err
+	}
+	b, err := kc.marshal()
+	if err != nil {
+		return Cluster{}, cleanup, "Unable to serialize kubeconfig", err
+	}
+
+	// Created readable only by the current user
+	f, err := ioutil.TempFile("", "spinnaker-tools-kubeconfig")
+	if err != nil {
+		return Cluster{}, cleanup, "Unable to create temporary kubeconfig", err
+	}
+	// Holds a token, so also removed if interrupted before the cleanup runs
+	done := utils.RemoveOnInterrupt(f.Name())
+	cleanup = func() {
+		os.Remove(f.Name())
+		done()
+	}
+	_, err = f.Write(b)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return Cluster{}, cleanup, "Unable to write temporary kubeconfig", err
+	}
+	return Cluster{KubeconfigFile: f.Name(), Context: ClusterContext{ContextName: KubeconfigContext(sa)}}, cleanup, "", nil
+}
+
+// Creates a SelfSubjectRulesReview in the namespace, which returns the rules of whoever makes it
+// Returns rules, whether they may be incomplete, error string, error
+// Called by DescribeServiceAccount, on the Cluster from asServiceAccount
+func (c *Cluster) reviewRules(namespace string, verbose bool) ([]PolicyRule, bool, string, error) {
+	review := `apiVersion: authorization.k8s.io/v1
+kind: SelfSubjectRulesReview
+spec:
+  namespace: ` + namespace + "\n"
+	options := c.buildCommand([]string{"create", "-f", "-", "-o=json"}, verbose)
+	o, bserr, err := utils.RunCommandInputOutput(verbose, "kubectl", review, options...)
+	if err != nil {
+		return nil, false, "SelfSubjectRulesReview in namespace " + namespace + " failed:\n" + bserr.String(), err
+	}
+
+	var r struct {
+		Status struct {
+			ResourceRules    []PolicyRule `json:"resourceRules"`
+			NonResourceRules []PolicyRule `json:"nonResourceRules"`
+			Incomplete       bool         `json:"incomplete"`
+		} `json:"status"`
+	}
+	if err := json.NewDecoder(o).Decode(&r); err != nil {
+		return nil, false, "Cannot decode JSON for SelfSubjectRulesReview", err
+	}
+	return append(r.Status.ResourceRules, r.Status.NonResourceRules...), r.Status.Incomplete, "", nil
+}
+
+// Builds the permission matrix of the rules
+func newPermissionMatrix(namespace string, rules []PolicyRule) PermissionMatrix {
+	m := PermissionMatrix{Namespace: namespace}
+	resources, urls := expandRules(rules)
+
+	verbs := map[string]bool{}
+	for _, byVerb := range resources {
+		for verb := range byVerb {
+			verbs[verb] = true
+		}
+	}
+	m.Verbs = append([]string{}, allVerbs...)
+	var others []string
+	for verb := range verbs {
+		if !contains(m.Verbs, verb) {
+			others = append(others, verb)
+		}
+	}
+	sort.Strings(others)
+	m.Verbs = append(m.Verbs, others...)
+
+	m.Resources = permissionRows(resources)
+	m.NonResourceURLs = permissionRows(urls)
+	return m
+}
+
+func permissionRows(cells map[string]map[string]string) []ResourcePermissions {
+	rows := []ResourcePermissions{}
+	for resource, byVerb := range cells {
+		rows = append(rows, ResourcePermissions{Resource: resource, Verbs: byVerb})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Resource < rows[j].Resource })
+	return rows
+}
+
+// Expands rules into what each verb allows on each resource, and on each non-resource URL
+// A * verb allows every verb, so the usual verbs are marked too
+func expandRules(rules []PolicyRule) (map[string]map[string]string, map[string]map[string]string) {
+	resources := map[string]map[string]string{}
+	urls := map[string]map[string]string{}
+	allow := func(cells map[string]map[string]string, key string, verbs []string, access string) {
+		if cells[key] == nil {
+			cells[key] = map[string]string{}
+		}
+		for _, verb := range verbs {
+			expanded := []string{verb}
+			if verb == "*" {
+				expanded = append(expanded, allVerbs...)
+			}
+			for _, v := range expanded {
+				if cells[key][v] != AllAccess {
+					cells[key][v] = access
+				}
+			}
+		}
+	}
+
+	for _, rule := range rules {
+		for _, url := range rule.NonResourceURLs {
+			allow(urls, url, rule.Verbs, AllAccess)
+		}
+		access := AllAccess
+		if len(rule.ResourceNames) != 0 {
+			access = SomeAccess
+		}
+		for _, group := range rule.APIGroups {
+			for _, resource := range rule.Resources {
+				key := resource
+				if group != "" {
+					key += "." + group
+				}
+				allow(resources, key, rule.Verbs, access)
+			}
+		}
+	}
+	return resources, urls
+}
+
+// Describes where the rules granted by the bindings and those reported by SelfSubjectRulesReview differ,
+// one resource per line
+func ruleMismatches(granted []PolicyRule, reviewed []PolicyRule) []string {
+	var mismatches []string
+	compare := func(what string, from map[string]map[string]string, to map[string]map[string]string, why string) {
+		var keys []string
+		for key := range from {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			var verbs []string
+			for verb, access := range from[key] {
+				if to[key][verb] != access {
+					verbs = append(verbs, verb)
+				}
+			}
+			if len(verbs) != 0 {
+				sort.Strings(verbs)
+				mismatches = append(mismatches, what+" "+key+": "+strings.Join(verbs, ", ")+" "+why)
+			}
+		}
+	}
+
+	grantedResources, grantedURLs := expandRules(granted)
+	reviewedResources, reviewedURLs := expandRules(reviewed)
+	compare("resource", grantedResources, reviewedResources, "granted by the bindings, but not reported by the API server")
+	compare("resource", reviewedResources, grantedResources, "reported by the API server, but not granted by the bindings")
+	compare("URL", grantedURLs, reviewedURLs, "granted by the bindings, but not reported by the API server")
+	compare("URL", reviewedURLs, grantedURLs, "reported by the API server, but not granted by the bindings")
+	return mismatches
+}
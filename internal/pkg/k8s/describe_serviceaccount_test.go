@@ -0,0 +1,66 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPermissionMatrix(t *testing.T) {
+	m := newPermissionMatrix("apps", []PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: readVerbs},
+		{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"get"}, ResourceNames: []string{"registry"}},
+		{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: []string{"*"}},
+		{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get", "escalate"}, ResourceNames: []string{"web"}},
+		{NonResourceURLs: []string{"/healthz"}, Verbs: []string{"get"}},
+	})
+	assert.Equal(t, "apps", m.Namespace)
+	assert.Equal(t, append(append([]string{}, allVerbs...), "*", "escalate"), m.Verbs)
+	assert.Equal(t, []ResourcePermissions{
+		{Resource: "deployments.apps", Verbs: map[string]string{
+			"*": AllAccess, "get": AllAccess, "list": AllAccess, "watch": AllAccess, "create": AllAccess,
+			"update": AllAccess, "patch": AllAccess, "delete": AllAccess, "deletecollection": AllAccess,
+		}},
+		// A rule for some names doesn't narrow another rule for all of them
+		{Resource: "pods", Verbs: map[string]string{"get": AllAccess, "list": AllAccess, "watch": AllAccess, "escalate": SomeAccess}},
+		{Resource: "secrets", Verbs: map[string]string{"get": SomeAccess}},
+	}, m.Resources)
+	assert.Equal(t, []ResourcePermissions{{Resource: "/healthz", Verbs: map[string]string{"get": AllAccess}}}, m.NonResourceURLs)
+}
+
+func TestRuleMismatches(t *testing.T) {
+	granted := []PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: readVerbs},
+		{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: []string{"get"}},
+	}
+	assert.Empty(t, ruleMismatches(granted, granted))
+
+	reviewed := []PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get", "list", "watch", "delete"}},
+		{NonResourceURLs: []string{"/api"}, Verbs: []string{"get"}},
+	}
+	assert.Equal(t, []string{
+		"resource deployments.apps: get granted by the bindings, but not reported by the API server",
+		"resource pods: delete reported by the API server, but not granted by the bindings",
+		"URL /api: get reported by the API server, but not granted by the bindings",
+	}, ruleMismatches(granted, reviewed))
+}
+
+func TestSubjectsInclude(t *testing.T) {
+	sa := ServiceAccount{Namespace: "spinnaker", ServiceAccountName: "deployer"}
+	self := subjectJSON{Kind: "ServiceAccount", Name: "deployer", Namespace: "spinnaker"}
+	group := subjectJSON{Kind: "Group", Name: "system:serviceaccounts:spinnaker"}
+	user := subjectJSON{Kind: "User", Name: "system:serviceaccount:spinnaker:deployer"}
+
+	included, shared := subjectsInclude([]subjectJSON{self}, sa, false)
+	assert.True(t, included)
+	assert.False(t, shared)
+	included, _ = subjectsInclude([]subjectJSON{group}, sa, false)
+	assert.False(t, included)
+	included, shared = subjectsInclude([]subjectJSON{group}, sa, true)
+	assert.True(t, included)
+	assert.True(t, shared)
+	included, shared = subjectsInclude([]subjectJSON{user, {Kind: "User", Name: "alice"}}, sa, true)
+	assert.True(t, included)
+	assert.True(t, shared)
+}
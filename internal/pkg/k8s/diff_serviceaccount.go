@@ -0,0 +1,449 @@
+package k8s
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/armory/spinnaker-tools/internal/pkg/utils"
+	"github.com/manifoldco/promptui"
+)
+
+// ChangeAction : What applying a service account's manifests does to an object
+type ChangeAction string
+
+const (
+	// CreateObject : The object doesn't exist yet
+	CreateObject ChangeAction = "create"
+	// UpdateObject : The object exists, and differs from its manifest
+	UpdateObject ChangeAction = "update"
+	// ReplaceObject : The object is a binding whose roleRef changed, which can't be updated, so it is
+	// deleted and created again
+	ReplaceObject ChangeAction = "replace"
+	// DeleteObject : The object was created for the service account by an earlier run, and is no longer
+	// wanted (such as the RoleBinding in a namespace that is no longer a target namespace)
+	DeleteObject ChangeAction = "delete"
+	// KeepObject : The object is a binding adopt took over, which isn't in the manifests but is only
+	// deleted with the service account (see KeepAdopted)
+	KeepObject ChangeAction = "kept"
+	// UnchangedObject : The object matches its manifest, and is left alone
+	UnchangedObject ChangeAction = "unchanged"
+)
+
+// ObjectChange : What applying a service account's manifests does to one object
+type ObjectChange struct {
+	Object ObjectRef
+	Action ChangeAction
+	// For update and replace, the fields that differ from the object in the cluster
+	Fields []string
+}
+
+// ServiceAccountDiff : How the objects in the cluster for a service account differ from its manifests,
+// as found by DiffServiceAccount
+type ServiceAccountDiff struct {
+	// Whether the ServiceAccount already exists
+	Existing bool
+	// For an existing service account, how its access changes, such as "role: cluster-admin -> read-only"
+	Notes []string
+	// In the order they are applied; deletions last, so access is never missing part way through
+	Changes []ObjectChange
+}
+
+// Changed : Whether applying the manifests changes anything
+func (d ServiceAccountDiff) Changed() bool {
+	for _, change := range d.Changes {
+		if change.Action != UnchangedObject && change.Action != KeepObject {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsConfirmation : Whether applying the manifests changes or deletes anything that already exists
+func (d ServiceAccountDiff) NeedsConfirmation() bool {
+	for _, change := range d.Changes {
+		if change.Action != UnchangedObject && change.Action != KeepObject && change.Action != CreateObject {
+			return true
+		}
+	}
+	return false
+}
+
+// Summary : Describes the diff, the notes first and then one object per line
+func (d ServiceAccountDiff) Summary() []string {
+	lines := append([]string{}, d.Notes...)
+	for _, change := range d.Changes {
+		action := string(change.Action)
+		if change.Action == KeepObject {
+			action += " (adopted)"
+		}
+		line := fmt.Sprintf("%-9s %s", action, change.Object)
+		if len(change.Fields) != 0 {
+			line += " (" + strings.Join(change.Fields, ", ") + ")"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// The change to an object; ok is false if the diff doesn't include it
+func (d *ServiceAccountDiff) change(o ObjectRef) (ObjectChange, bool) {
+	if d == nil {
+		return ObjectChange{}, false
+	}
+	for _, change := range d.Changes {
+		if change.Object == o {
+			return change, true
+		}
+	}
+	return ObjectChange{}, false
+}
+
+// Annotations that don't make an object differ from its manifest: provenance, which is different on
+// every run, the credential annotations CreateKubeconfig adds to the ServiceAccount, and the bindings
+// adopt recorded on it (which apply leaves alone, as they aren't in its last-applied configuration)
+var ignoredAnnotations = map[string]bool{
+	AppliedByAnnotation:         true,
+	AppliedAtAnnotation:         true,
+	VersionAnnotation:           true,
+	CommandAnnotation:           true,
+	CredentialAnnotation:        true,
+	CredentialIssuedAnnotation:  true,
+	CredentialExpiresAnnotation: true,
+	AdoptedBindingsAnnotation:   true,
+}
+
+// DiffServiceAccount : Compares the manifests for a defined service account with what is in the cluster:
+//   - Each object in the manifests is created if it doesn't exist, updated (or replaced, for bindings
+//     whose roleRef changed) if it differs, and otherwise left unchanged
+//   - Bindings and roles created for the service account by an earlier run that aren't in the manifests
+//     any more are deleted; the ServiceAccount and token Secrets are never deleted, as kubeconfigs
+//     may still use them, and neither are the bindings adopt took over (see KeepAdopted)
+//
+// The diff is kept on the service account, so CreateServiceAccount only applies what changed
+// Returns diff, error string, error
+func (c *Cluster) DiffServiceAccount(sa *ServiceAccount, verbose bool) (ServiceAccountDiff, string, error) {
+	var diff ServiceAccountDiff
+	manifests, err := ServiceAccountManifests(*sa, verbose)
+	if err != nil {
+		return diff, "Unable to render manifests for service account", err
+	}
+	objects, err := parseObjects(strings.Join(manifests, ""))
+	if err != nil {
+		return diff, "Unable to parse manifests for service account", err
+	}
+
+	changes, desiredAnnotations, liveAnnotations, serr, err := sa.manifestChanges(objects, func(o ObjectRef) (object, string, error) {
+		return c.getObject(o, verbose)
+	})
+	if err != nil {
+		return diff, serr, err
+	}
+	diff.Changes = changes
+	diff.Existing = liveAnnotations != nil
+	wanted := map[ObjectRef]bool{}
+	for _, change := range changes {
+		wanted[change.Object] = true
+	}
+
+	existing, serr, err := c.findServiceAccountObjects(*sa, verbose)
+	if err != nil {
+		return diff, serr, err
+	}
+	var current []string
+	for _, o := range existing.Objects {
+		if o.Kind == "RoleBinding" {
+			current = append(current, o.Namespace)
+		}
+	}
+	diff.Changes = append(diff.Changes, sa.staleChanges(existing.Objects, wanted, liveAnnotations)...)
+
+	if diff.Existing {
+		diff.Notes = accessNotes(
+			newServiceAccountInfo(*sa, liveAnnotations, current),
+			newServiceAccountInfo(*sa, desiredAnnotations, sa.TargetNamespaces),
+		)
+	}
+	sa.diff = &diff
+	return diff, "", nil
+}
+
+// Compares each object in the manifests with the one in the cluster, got with get (nil if it doesn't
+// exist); objects in (or that are) namespaces being created aren't looked for, as they can't exist yet
+// Called by DiffServiceAccount
+// Returns changes, annotations of the ServiceAccount in the manifests and in the cluster (nil if it
+// doesn't exist yet), error string, error
+func (sa ServiceAccount) manifestChanges(objects []object, get func(ObjectRef) (object, string, error)) ([]ObjectChange, map[string]string, map[string]string, string, error) {
+	newNamespaces := map[string]bool{}
+	for _, namespace := range sa.newTargetNamespaces {
+		newNamespaces[namespace] = true
+	}
+	if sa.newNamespace {
+		newNamespaces[sa.Namespace] = true
+	}
+
+	var changes []ObjectChange
+	var desiredAnnotations, liveAnnotations map[string]string
+	for _, desired := range objects {
+		o := desired.ref()
+		if o.Kind == "ServiceAccount" {
+			desiredAnnotations = desired.annotations()
+		}
+		if newNamespaces[o.Namespace] || (o.Kind == "Namespace" && newNamespaces[o.Name]) {
+			changes = append(changes, ObjectChange{Object: o, Action: CreateObject})
+			continue
+		}
+
+		live, serr, err := get(o)
+		if err != nil {
+			return changes, desiredAnnotations, liveAnnotations, serr, err
+		}
+		if live == nil {
+			changes = append(changes, ObjectChange{Object: o, Action: CreateObject})
+			continue
+		}
+		if o.Kind == "ServiceAccount" {
+			liveAnnotations = live.annotations()
+		}
+		change := ObjectChange{Object: o, Action: UnchangedObject, Fields: diffObject(desired, live)}
+		if contains(change.Fields, "roleRef") {
+			change.Action = ReplaceObject
+		} else if len(change.Fields) != 0 {
+			change.Action = UpdateObject
+		}
+		changes = append(changes, change)
+	}
+	return changes, desiredAnnotations, liveAnnotations, "", nil
+}
+
+// The changes to the bindings and roles found for the service account that aren't wanted (in its
+// manifests): deleted, unless adopt took them over (see KeepAdopted), given the annotations of the
+// live ServiceAccount
+// Called by DiffServiceAccount
+func (sa ServiceAccount) staleChanges(existing []ObjectRef, wanted map[ObjectRef]bool, annotations map[string]string) []ObjectChange {
+	var changes []ObjectChange
+	for _, o := range existing {
+		switch o.Kind {
+		case "RoleBinding", "Role", "ClusterRoleBinding", "ClusterRole":
+		default:
+			continue
+		}
+		switch {
+		case wanted[o]:
+		case sa.KeepAdopted(o, annotations):
+			changes = append(changes, ObjectChange{Object: o, Action: KeepObject})
+		default:
+			changes = append(changes, ObjectChange{Object: o, Action: DeleteObject})
+		}
+	}
+	return changes
+}
+
+// Describes how the access of a service account changes, from what it has to what it will have
+// Called by DiffServiceAccount
+func accessNotes(from ServiceAccountInfo, to ServiceAccountInfo) []string {
+	var notes []string
+	changed := func(what string, from string, to string) {
+		if from != to {
+			notes = append(notes, what+": "+orNone(from)+" -> "+orNone(to))
+		}
+	}
+	changed("role", from.Role, to.Role)
+	changed("scope", from.Scope, to.Scope)
+	changed("namespace binding", from.NamespaceBinding, to.NamespaceBinding)
+	changed("target namespace selector", from.TargetNamespaceSelector, to.TargetNamespaceSelector)
+
+	added, removed := diffNamespaces(from.TargetNamespaces, to.TargetNamespaces)
+	sort.Strings(added)
+	sort.Strings(removed)
+	if len(added) != 0 {
+		notes = append(notes, "target namespaces added: "+strings.Join(added, ", "))
+	}
+	if len(removed) != 0 {
+		notes = append(notes, "target namespaces removed: "+strings.Join(removed, ", "))
+	}
+	return notes
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
+
+// ConfirmChanges : Asks whether to go ahead with changing existing objects, unless told to already
+// Returns error string, error
+func ConfirmChanges(assumeYes bool) (string, error) {
+	if assumeYes {
+		return "", nil
+	}
+	if !utils.IsInteractive() {
+		return "There is no terminal to confirm changing existing objects on (use --yes)", errors.New("changes not confirmed")
+	}
+	confirmPrompt := promptui.Prompt{
+		Label:     "Apply these changes",
+		IsConfirm: true,
+	}
+	if _, err := confirmPrompt.Run(); err != nil {
+		return "Not changing anything", errors.New("changes not confirmed")
+	}
+	return "", nil
+}
+
+// A Kubernetes object, as decoded from YAML or JSON
+type object map[string]interface{}
+
+func (obj object) ref() ObjectRef {
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	return ObjectRef{
+		Kind:      fmt.Sprint(obj["kind"]),
+		Namespace: stringField(metadata, "namespace"),
+		Name:      stringField(metadata, "name"),
+	}
+}
+
+func (obj object) annotations() map[string]string {
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	return stringMap(metadata["annotations"])
+}
+
+func (obj object) labels() map[string]string {
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	return stringMap(metadata["labels"])
+}
+
+func stringField(m map[string]interface{}, key string) string {
+	if m[key] == nil {
+		return ""
+	}
+	return fmt.Sprint(m[key])
+}
+
+func stringMap(v interface{}) map[string]string {
+	m, _ := v.(map[string]interface{})
+	out := map[string]string{}
+	for key, value := range m {
+		out[key] = fmt.Sprint(value)
+	}
+	return out
+}
+
+// Decodes the objects in a multi-document manifest (see ManifestObjects)
+func parseObjects(manifest string) ([]object, error) {
+	maps, err := ManifestObjects(manifest)
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]object, len(maps))
+	for i, m := range maps {
+		objects[i] = object(m)
+	}
+	return objects, nil
+}
+
+// Gets an object from the cluster; nil if it doesn't exist
+// Returns object, error string, error
+func (c *Cluster) getObject(o ObjectRef, verbose bool) (object, string, error) {
+	command := []string{"get", strings.ToLower(o.Kind), o.Name, "--ignore-not-found", "-o=json"}
+	if o.Namespace != "" {
+		command = append(command, "-n", o.Namespace)
+	}
+	out, bserr, err := utils.RunCommand(verbose, "kubectl", c.buildCommand(command, verbose)...)
+	if err != nil {
+		return nil, "Unable to get " + o.String() + ":\n" + bserr.String(), err
+	}
+	if strings.TrimSpace(out.String()) == "" {
+		return nil, "", nil
+	}
+	var live object
+	if err := json.NewDecoder(out).Decode(&live); err != nil {
+		return nil, "Cannot decode JSON for " + o.String(), err
+	}
+	return live, "", nil
+}
+
+// The top-level fields (or metadata.labels and metadata.annotations) of the desired object that differ
+// from the live one
+// Fields the API server adds or defaults (status, resourceVersion, ...) are ignored, as are labels and
+// annotations added by anything else; annotations this tool manages that are no longer wanted count as
+// differences, as applying removes them
+func diffObject(desired object, live object) []string {
+	var fields []string
+	if !subset(desired.labels(), live.labels()) {
+		fields = append(fields, "metadata.labels")
+	}
+	desiredAnnotations, liveAnnotations := desired.annotations(), live.annotations()
+	for key := range liveAnnotations {
+		if _, ok := desiredAnnotations[key]; ignoredAnnotations[key] || (!ok && !strings.HasPrefix(key, annotationPrefix)) {
+			delete(liveAnnotations, key)
+		}
+	}
+	for key := range desiredAnnotations {
+		if ignoredAnnotations[key] {
+			delete(desiredAnnotations, key)
+		}
+	}
+	if !subset(desiredAnnotations, liveAnnotations) || !subset(liveAnnotations, desiredAnnotations) {
+		fields = append(fields, "metadata.annotations")
+	}
+
+	var keys []string
+	for key := range desired {
+		switch key {
+		case "apiVersion", "kind", "metadata":
+		default:
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !subset(desired[key], live[key]) {
+			fields = append(fields, key)
+		}
+	}
+	return fields
+}
+
+// Whether everything set in desired is set to the same in live
+// Lists must be the same length, and match element by element
+func subset(desired interface{}, live interface{}) bool {
+	switch d := desired.(type) {
+	case nil:
+		return true
+	case map[string]string:
+		l, _ := live.(map[string]string)
+		for key, value := range d {
+			if lv, ok := l[key]; !ok || lv != value {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		l, _ := live.(map[string]interface{})
+		for key, value := range d {
+			if !subset(value, l[key]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		l, _ := live.([]interface{})
+		if len(d) != len(l) {
+			return false
+		}
+		for i := range d {
+			if !subset(d[i], l[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	// Scalars; an empty string is the same as leaving the field out
+	if live == nil {
+		return fmt.Sprint(desired) == ""
+	}
+	return fmt.Sprint(desired) == fmt.Sprint(live)
+}
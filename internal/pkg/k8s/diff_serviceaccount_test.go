@@ -0,0 +1,171 @@
+package k8s
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffObject(t *testing.T) {
+	sa := ServiceAccount{
+		Namespace:          "spinnaker",
+		ServiceAccountName: "deployer",
+		Role:               SpinnakerDeployerRole,
+		TargetNamespaces:   []string{"apps"},
+		NamespaceBinding:   RolePerNamespace,
+		Provenance:         Provenance{User: "alice", Version: "1.2.0"},
+	}
+	manifest, err := namespaceRoleBinding(sa, "apps", false)
+	assert.Nil(t, err)
+	objects, err := parseObjects(manifest)
+	assert.Nil(t, err)
+	binding := objects[len(objects)-1]
+	assert.Equal(t, ObjectRef{"RoleBinding", "apps", "spinnaker-deployer-binding"}, binding.ref())
+
+	// As the API server returns it: with server-set fields, other annotations, and an earlier run's provenance
+	live, err := parseObjects(strings.Replace(manifest, "alice", "bob", -1))
+	assert.Nil(t, err)
+	liveBinding := live[len(live)-1]
+	liveBinding["status"] = map[string]interface{}{}
+	liveBinding["metadata"].(map[string]interface{})["resourceVersion"] = "42"
+	liveBinding["metadata"].(map[string]interface{})["annotations"].(map[string]interface{})["example.com/owner"] = "team"
+	assert.Empty(t, diffObject(binding, liveBinding))
+
+	liveBinding["roleRef"].(map[string]interface{})["name"] = "edit"
+	liveBinding["metadata"].(map[string]interface{})["annotations"].(map[string]interface{})[TargetNamespaceSelectorAnnotation] = "team=a"
+	assert.Equal(t, []string{"metadata.annotations", "roleRef"}, diffObject(binding, liveBinding))
+}
+
+func TestDiffObjectAdopted(t *testing.T) {
+	sa := ServiceAccount{Namespace: "spinnaker", ServiceAccountName: "deployer", Role: ClusterAdminRole}
+	manifest, err := serviceAccountDefinition(sa, false)
+	assert.Nil(t, err)
+	objects, err := parseObjects(manifest)
+	assert.Nil(t, err)
+
+	// adopt annotated it with its bindings, which the manifest doesn't have
+	live, err := parseObjects(manifest)
+	assert.Nil(t, err)
+	live[0]["metadata"].(map[string]interface{})["annotations"].(map[string]interface{})[AdoptedBindingsAnnotation] = "ClusterRoleBinding deployer"
+	assert.Empty(t, diffObject(objects[0], live[0]))
+}
+
+func TestSubset(t *testing.T) {
+	rules := []interface{}{map[string]interface{}{"apiGroups": []interface{}{""}, "verbs": []interface{}{"get"}}}
+	assert.True(t, subset(rules, []interface{}{map[string]interface{}{"apiGroups": []interface{}{""}, "verbs": []interface{}{"get"}, "extra": "x"}}))
+	assert.False(t, subset(rules, []interface{}{map[string]interface{}{"apiGroups": []interface{}{""}, "verbs": []interface{}{"get", "list"}}}))
+	assert.False(t, subset(rules, nil))
+	assert.True(t, subset(map[string]interface{}{"apiGroup": ""}, map[string]interface{}{}))
+	assert.True(t, subset(map[string]interface{}{"value": "1"}, map[string]interface{}{"value": 1.0}))
+}
+
+func TestServiceAccountDiffSummary(t *testing.T) {
+	diff := ServiceAccountDiff{
+		Existing: true,
+		Notes:    []string{"target namespaces removed: old"},
+		Changes: []ObjectChange{
+			{Object: ObjectRef{"ServiceAccount", "spinnaker", "deployer"}, Action: UnchangedObject},
+			{Object: ObjectRef{"RoleBinding", "apps", "spinnaker-deployer-binding"}, Action: ReplaceObject, Fields: []string{"roleRef"}},
+			{Object: ObjectRef{"RoleBinding", "old", "spinnaker-deployer-binding"}, Action: DeleteObject},
+		},
+	}
+	assert.Equal(t, []string{
+		"target namespaces removed: old",
+		"unchanged ServiceAccount spinnaker/deployer",
+		"replace   RoleBinding apps/spinnaker-deployer-binding (roleRef)",
+		"delete    RoleBinding old/spinnaker-deployer-binding",
+	}, diff.Summary())
+	assert.True(t, diff.Changed())
+	assert.True(t, diff.NeedsConfirmation())
+
+	diff.Changes = []ObjectChange{
+		{Object: ObjectRef{"ServiceAccount", "spinnaker", "deployer"}, Action: UnchangedObject},
+		{Object: ObjectRef{"RoleBinding", "new", "spinnaker-deployer-binding"}, Action: CreateObject},
+	}
+	assert.True(t, diff.Changed())
+	assert.False(t, diff.NeedsConfirmation())
+	diff.Changes = diff.Changes[:1]
+	assert.False(t, diff.Changed())
+}
+
+func TestManifestChangesNewNamespaces(t *testing.T) {
+	sa := ServiceAccount{
+		Namespace:           "spinnaker",
+		ServiceAccountName:  "deployer",
+		Role:                SpinnakerDeployerRole,
+		TargetNamespaces:    []string{"apps", "new-apps"},
+		NamespaceBinding:    RolePerNamespace,
+		newNamespace:        true,
+		newTargetNamespaces: []string{"new-apps"},
+	}
+	manifests, err := ServiceAccountManifests(sa, false)
+	assert.Nil(t, err)
+	objects, err := parseObjects(strings.Join(manifests, ""))
+	assert.Nil(t, err)
+
+	// Only apps exists; nothing is looked for in the namespaces being created
+	var looked []ObjectRef
+	changes, _, live, _, err := sa.manifestChanges(objects, func(o ObjectRef) (object, string, error) {
+		looked = append(looked, o)
+		return nil, "", nil
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, live)
+	assert.Equal(t, []ObjectRef{
+		{"Role", "apps", "spinnaker-deployer-local-admin"},
+		{"RoleBinding", "apps", "spinnaker-deployer-binding"},
+	}, looked)
+
+	summary := ServiceAccountDiff{Changes: changes}.Summary()
+	assert.Contains(t, summary, "create    Namespace spinnaker")
+	assert.Contains(t, summary, "create    Namespace new-apps")
+	assert.Contains(t, summary, "create    ServiceAccount spinnaker/deployer")
+	assert.Contains(t, summary, "create    RoleBinding new-apps/spinnaker-deployer-binding")
+}
+
+func TestStaleChangesAdopted(t *testing.T) {
+	sa := ServiceAccount{Namespace: "spinnaker", ServiceAccountName: "deployer", Role: ClusterAdminRole}
+	// Re-running create-service-account on a service account adopted with a cluster-admin binding of its own
+	existing := []ObjectRef{
+		{"ClusterRoleBinding", "", "legacy-crb"},
+		{"RoleBinding", "old", "spinnaker-deployer-binding"},
+		{"RoleBinding", "apps", "ci-edit"},
+		{"ServiceAccount", "spinnaker", "deployer"},
+	}
+	wanted := map[ObjectRef]bool{
+		{"ServiceAccount", "spinnaker", "deployer"}:            true,
+		{"ClusterRoleBinding", "", "spinnaker-deployer-admin"}: true,
+	}
+	annotations := map[string]string{
+		AdoptedBindingsAnnotation: "ClusterRoleBinding legacy-crb, RoleBinding old/spinnaker-deployer-binding",
+	}
+	changes := sa.staleChanges(existing, wanted, annotations)
+	assert.Equal(t, []ObjectChange{
+		// Adopted, so it survives the re-run
+		{Object: ObjectRef{"ClusterRoleBinding", "", "legacy-crb"}, Action: KeepObject},
+		// Adopted, but named like the ones the tool creates
+		{Object: ObjectRef{"RoleBinding", "old", "spinnaker-deployer-binding"}, Action: DeleteObject},
+		// Labelled for it, but not adopted
+		{Object: ObjectRef{"RoleBinding", "apps", "ci-edit"}, Action: DeleteObject},
+	}, changes)
+
+	diff := ServiceAccountDiff{Changes: changes[:1]}
+	assert.Equal(t, []string{"kept (adopted) ClusterRoleBinding legacy-crb"}, diff.Summary())
+	assert.False(t, diff.Changed())
+	assert.False(t, diff.NeedsConfirmation())
+	// Without the annotation, nothing was adopted
+	assert.Equal(t, DeleteObject, sa.staleChanges(existing, wanted, nil)[0].Action)
+}
+
+func TestAccessNotes(t *testing.T) {
+	from := ServiceAccountInfo{Role: "cluster-admin", Scope: "cluster"}
+	to := ServiceAccountInfo{Role: "read-only", Scope: "namespaced", NamespaceBinding: "role", TargetNamespaces: []string{"b", "a"}}
+	assert.Equal(t, []string{
+		"role: cluster-admin -> read-only",
+		"scope: cluster -> namespaced",
+		"namespace binding: (none) -> role",
+		"target namespaces added: a, b",
+	}, accessNotes(from, to))
+	assert.Empty(t, accessNotes(to, to))
+}
@@ -0,0 +1,131 @@
+package k8s
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/armory/spinnaker-tools/internal/pkg/utils"
+)
+
+// DescribeKubeconfig : Renders the kubeconfig CreateKubeconfig would write for the service account
+// The credentials only exist once the service account does, so the user holds placeholders describing
+// where they will come from
+// Returns kubeconfig, error string, error
+func (c *Cluster) DescribeKubeconfig(sa ServiceAccount) (string, string, error) {
+	source, err := loadKubeconfig(c.KubeconfigFile)
+	if err != nil {
+		return "", "Unable to load kubeconfig " + c.KubeconfigFile, err
+	}
+
+	// Without a token, extract sets up a client certificate user
+	cred := credential{}
+	if sa.Credentials != CertificateCredentials {
+		cred.Token = credentialPlaceholder(sa)
+	}
+	kc, err := source.extract(c.Context.ContextName, KubeconfigContext(sa), sa.Namespace, cred, filepath.Dir(c.KubeconfigFile))
+	if err != nil {
+		return "", "Unable to build kubeconfig for context " + c.Context.ContextName, err
+	}
+	if sa.Credentials == CertificateCredentials {
+		kc.Users[0].User = kubeconfigUser{
+			ClientCertificateData: credentialPlaceholder(sa),
+			ClientKeyData:         "<private key generated for the certificate>",
+		}
+	}
+
+	b, err := kc.marshal()
+	if err != nil {
+		return "", "Unable to serialize kubeconfig", err
+	}
+	return string(b), "", nil
+}
+
+// Describes the credential CreateKubeconfig would put in the kubeconfig
+func credentialPlaceholder(sa ServiceAccount) string {
+	if sa.Credentials == CertificateCredentials {
+		if sa.Certificate.Duration == 0 {
+			return "<client certificate issued through a CertificateSigningRequest>"
+		}
+		return "<client certificate issued through a CertificateSigningRequest, valid for " + sa.Certificate.Duration.String() + ">"
+	}
+	if sa.Token.SecretName != "" {
+		return "<token from Secret " + sa.Namespace + "/" + sa.Token.SecretName + ">"
+	}
+	if sa.Token.Duration == 0 {
+		return "<token for the service account>"
+	}
+	return "<token for the service account, requested for " + sa.Token.Duration.String() + " on clusters without token Secrets>"
+}
+
+// ServerDryRun : Validates manifests against the API server, including admission webhooks and policies,
+// with kubectl apply --dry-run=server, without persisting anything
+// Nothing is created in a dry run, so objects in namespaces that don't exist yet can't be validated;
+// they are left out, and returned as "Kind namespace/name"
+// Returns skipped objects, error string, error
+func (c *Cluster) ServerDryRun(sa ServiceAccount, manifests []string, verbose bool) ([]string, string, error) {
+	newNamespaces := append([]string{}, sa.newTargetNamespaces...)
+	if sa.newNamespace {
+		newNamespaces = append(newNamespaces, sa.Namespace)
+	}
+
+	manifest, skipped, err := withoutNamespaces(strings.Join(manifests, ""), newNamespaces)
+	if err != nil {
+		return nil, "Unable to parse manifests", err
+	}
+	if manifest == "" {
+		return skipped, "", nil
+	}
+
+	options := c.buildCommand([]string{
+		"apply", "--dry-run=server", "-f", "-",
+	}, verbose)
+	if err := utils.RunCommandInput(verbose, "kubectl", manifest, options...); err != nil {
+		return skipped, "Server-side dry run rejected the manifests", err
+	}
+	return skipped, "", nil
+}
+
+// Removes the objects in any of the namespaces from a multi-document manifest
+// Returns the remaining manifest, and the removed objects as "Kind namespace/name"
+func withoutNamespaces(manifest string, namespaces []string) (string, []string, error) {
+	skip := map[string]bool{}
+	for _, namespace := range namespaces {
+		skip[namespace] = true
+	}
+
+	out, removed, err := filterManifest(manifest, func(o ObjectRef) bool {
+		return !skip[o.Namespace]
+	})
+	var skipped []string
+	for _, o := range removed {
+		skipped = append(skipped, o.String())
+	}
+	return out, skipped, err
+}
+
+// Keeps the objects of a multi-document manifest that keep returns true for
+// Returns the remaining manifest, and the removed objects
+func filterManifest(manifest string, keep func(ObjectRef) bool) (string, []ObjectRef, error) {
+	objects, err := parseObjects(manifest)
+	if err != nil {
+		return "", nil, err
+	}
+	var out bytes.Buffer
+	var removed []ObjectRef
+	for _, o := range objects {
+		if !keep(o.ref()) {
+			removed = append(removed, o.ref())
+			continue
+		}
+		b, err := yaml.Marshal(map[string]interface{}(o))
+		if err != nil {
+			return "", nil, err
+		}
+		out.WriteString("---\n")
+		out.Write(b)
+	}
+	return out.String(), removed, nil
+}
@@ -0,0 +1,63 @@
+package k8s
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDryRunManifestsIncludeNewNamespaces(t *testing.T) {
+	sa := ServiceAccount{
+		Namespace:           "spinnaker",
+		ServiceAccountName:  "deployer",
+		Role:                SpinnakerDeployerRole,
+		TargetNamespaces:    []string{"apps", "new-apps"},
+		NamespaceBinding:    RolePerNamespace,
+		newNamespace:        true,
+		newTargetNamespaces: []string{"new-apps"},
+	}
+	manifests, err := ServiceAccountManifests(sa, false)
+	assert.Nil(t, err)
+	assert.Contains(t, manifests[0], "kind: Namespace\nmetadata:\n  name: spinnaker\n")
+	assert.Contains(t, manifests[1], "kind: Namespace\nmetadata:\n  name: new-apps\n")
+	assert.Contains(t, manifests[2], "kind: ServiceAccount")
+
+	manifest, skipped, err := withoutNamespaces(strings.Join(manifests, ""), []string{"spinnaker", "new-apps"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"ServiceAccount spinnaker/deployer",
+		"Role new-apps/spinnaker-deployer-local-admin",
+		"RoleBinding new-apps/spinnaker-deployer-binding",
+	}, skipped)
+	assert.Nil(t, validateManifest(manifest))
+	assert.Equal(t, 2, strings.Count(manifest, "kind: Namespace"))
+	assert.Contains(t, manifest, "namespace: apps")
+	assert.NotContains(t, manifest, "namespace: new-apps")
+}
+
+func TestDescribeKubeconfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "config")
+	assert.Nil(t, ioutil.WriteFile(filename, []byte(sourceKubeconfig), 0600))
+	c := Cluster{KubeconfigFile: filename, Context: ClusterContext{ContextName: "staging-admin"}}
+
+	sa := ServiceAccount{Namespace: "spinnaker", ServiceAccountName: "spinnaker", Token: TokenOptions{Duration: time.Hour}}
+	kc, _, err := c.DescribeKubeconfig(sa)
+	assert.Nil(t, err)
+	assert.Contains(t, kc, "server: https://staging.example.com")
+	assert.Contains(t, kc, "token: <token for the service account, requested for 1h0m0s")
+	assert.NotContains(t, kc, "admin-token")
+
+	sa.Credentials = CertificateCredentials
+	kc, _, err = c.DescribeKubeconfig(sa)
+	assert.Nil(t, err)
+	assert.Contains(t, kc, "name: spinnaker-cert-user")
+	assert.Contains(t, kc, "client-key-data: <private key generated for the certificate>")
+}
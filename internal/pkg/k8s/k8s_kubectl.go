@@ -23,6 +23,7 @@ func (kvd *KubectlVersionDetails) GetMinorVersionInt() (int, error) {
 
 type KubectlVersion struct {
 	ClientVersion KubectlVersionDetails `json:"clientVersion"`
+	ServerVersion KubectlVersionDetails `json:"serverVersion"`
 }
 
 // GetKubectlVersion gets a machine readable version of kubectl version
@@ -45,6 +46,40 @@ func GetKubectlVersion(verbose bool) (KubectlVersion, error) {
 	return version, nil
 }
 
+// getServerVersion gets the version of both kubectl and the API server of the cluster
+// Called by getServerMinorVersion
+func (c *Cluster) getServerVersion(verbose bool) (KubectlVersion, error) {
+	options := c.buildCommand([]string{
+		"version",
+		"-o=json",
+	}, verbose)
+
+	o, stderr, err := utils.RunCommand(verbose, "kubectl", options...)
+	if err != nil {
+		return KubectlVersion{}, errors.New(stderr.String())
+	}
+
+	var version KubectlVersion
+	if err := json.NewDecoder(o).Decode(&version); err != nil {
+		return KubectlVersion{}, err
+	}
+	return version, nil
+}
+
+// Returns the minor version of the API server of the cluster, error string, error
+// Called by findTokenSecret and WaitForToken
+func (c *Cluster) getServerMinorVersion(verbose bool) (int, string, error) {
+	version, err := c.getServerVersion(verbose)
+	if err != nil {
+		return 0, "Unable to get Kubernetes version of the cluster", err
+	}
+	minor, err := version.ServerVersion.GetMinorVersionInt()
+	if err != nil {
+		return 0, "Unable to get minor version of the cluster", err
+	}
+	return minor, "", nil
+}
+
 // Called by DefineServiceAccount and DefineServiceAccount.promptNamespace
 func k8sValidator(input string) error {
 	matched, err := regexp.MatchString(`^[a-z]([-a-z0-9]*[a-z0-9])?$`, input)
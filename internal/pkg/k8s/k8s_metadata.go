@@ -0,0 +1,207 @@
+package k8s
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/armory/spinnaker-tools/internal/pkg/utils"
+	"github.com/armory/spinnaker-tools/internal/pkg/version"
+)
+
+// ManagedByLabel : Label on every object created by this tool (with value ManagedByValue)
+const ManagedByLabel = "app.kubernetes.io/managed-by"
+
+// ManagedByValue : Value of ManagedByLabel
+const ManagedByValue = "spinnaker-tools"
+
+// Labels on every object created for a service account, identifying it, so they can be found with
+// a label selector such as
+// spinnaker-tools.armory.io/service-account-namespace=spinnaker,spinnaker-tools.armory.io/service-account-name=spinnaker
+// (namespace and name are separate labels, as together they may not fit in a label value; names too
+// long for one are shortened, see labelValue)
+const (
+	// ServiceAccountNamespaceLabel : Namespace of the service account
+	ServiceAccountNamespaceLabel = annotationPrefix + "service-account-namespace"
+	// ServiceAccountNameLabel : Name of the service account
+	ServiceAccountNameLabel = annotationPrefix + "service-account-name"
+)
+
+// Annotations on every object created for a service account, recording the run of this tool that
+// last created or changed it (see Provenance)
+const (
+	// AppliedByAnnotation : The Kubernetes user that ran the tool
+	AppliedByAnnotation = annotationPrefix + "applied-by"
+	// AppliedAtAnnotation : When, in RFC 3339 format
+	AppliedAtAnnotation = annotationPrefix + "applied-at"
+	// VersionAnnotation : The version of the tool
+	VersionAnnotation = annotationPrefix + "version"
+	// CommandAnnotation : The command line (or controller) that created or changed it
+	CommandAnnotation = annotationPrefix + "command"
+)
+
+// Annotations recording how a ServiceAccount was set up, so later commands (such as sync) can
+// grant the same access without being told again
+const (
+	annotationPrefix = "spinnaker-tools.armory.io/"
+	// RoleAnnotation : The role preset
+	RoleAnnotation = annotationPrefix + "role"
+	// AllowSecretsAnnotation : Whether the role preset includes access to Secrets
+	AllowSecretsAnnotation = annotationPrefix + "allow-secrets"
+	// NamespaceBindingAnnotation : How access to target namespaces is granted
+	NamespaceBindingAnnotation = annotationPrefix + "namespace-binding"
+	// TargetNamespaceSelectorAnnotation : The label selector for target namespaces
+	TargetNamespaceSelectorAnnotation = annotationPrefix + "target-namespace-selector"
+)
+
+// Annotations on the ServiceAccount describing the credential in the last kubeconfig created for it,
+// for list
+const (
+	// CredentialAnnotation : token (requested through the TokenRequest API), token-secret, or certificate
+	CredentialAnnotation = annotationPrefix + "credential"
+	// CredentialIssuedAnnotation : When the kubeconfig was created, in RFC 3339 format
+	CredentialIssuedAnnotation = annotationPrefix + "credential-issued-at"
+	// CredentialExpiresAnnotation : When the credential expires, in RFC 3339 format (absent if it doesn't)
+	CredentialExpiresAnnotation = annotationPrefix + "credential-expires-at"
+)
+
+// CreatedForAnnotation : On namespaces created by this tool, the service account (namespace/name) they
+// were created for; delete-service-account only deletes namespaces created for the service account
+const CreatedForAnnotation = annotationPrefix + "created-for"
+
+// Fills in the role preset, namespace binding and target namespace selector of the service account
+// from the annotations CreateServiceAccount put on it
+// Called by SyncServiceAccount
+func (sa *ServiceAccount) setFromAnnotations(annotations map[string]string) error {
+	var err error
+	if sa.Role, err = ParseRole(annotations[RoleAnnotation]); err != nil {
+		return err
+	}
+	if sa.NamespaceBinding, err = ParseNamespaceBinding(annotations[NamespaceBindingAnnotation]); err != nil {
+		return err
+	}
+	if s, ok := annotations[AllowSecretsAnnotation]; ok {
+		if sa.AllowSecrets, err = strconv.ParseBool(s); err != nil {
+			return errors.New("invalid " + AllowSecretsAnnotation + " annotation " + s)
+		}
+	}
+	sa.TargetNamespaceSelector = annotations[TargetNamespaceSelectorAnnotation]
+	return nil
+}
+
+// The annotations recording how the service account was set up, read back by setFromAnnotations
+// Called by serviceAccountDefinition
+func (sa ServiceAccount) setupAnnotations() map[string]string {
+	annotations := map[string]string{
+		RoleAnnotation:         string(sa.Role),
+		AllowSecretsAnnotation: strconv.FormatBool(sa.AllowSecrets),
+	}
+	if sa.Namespaced() {
+		annotations[NamespaceBindingAnnotation] = string(sa.NamespaceBinding)
+	}
+	if sa.TargetNamespaceSelector != "" {
+		annotations[TargetNamespaceSelectorAnnotation] = sa.TargetNamespaceSelector
+	}
+	return annotations
+}
+
+// Provenance : Who created or changed the objects for a service account, when, and with what
+// Empty fields are left out of the annotations
+type Provenance struct {
+	User    string
+	Time    time.Time
+	Version string
+	Command string
+}
+
+// NewProvenance : Provenance for objects created now by the current user of the cluster, with this
+// version of the tool, by the command line args (redacted, as it is stored in the cluster)
+func (c *Cluster) NewProvenance(args []string, verbose bool) Provenance {
+	return Provenance{
+		User:    c.currentUser(verbose),
+		Time:    time.Now().UTC(),
+		Version: version.Version,
+		Command: strings.Join(utils.RedactArgs(args), " "),
+	}
+}
+
+// The user kubectl authenticates to the cluster as, from `kubectl auth whoami` (kubectl and Kubernetes
+// 1.28 and later), or failing that, the name of the user in the kubeconfig context
+// Called by NewProvenance
+func (c *Cluster) currentUser(verbose bool) string {
+	options := c.buildCommand([]string{
+		"auth", "whoami",
+		"-o", "jsonpath={.status.userInfo.username}",
+	}, verbose)
+	o, _, err := utils.RunCommand(verbose, "kubectl", options...)
+	if err == nil && strings.TrimSpace(o.String()) != "" {
+		return strings.TrimSpace(o.String())
+	}
+
+	kc, err := loadKubeconfig(c.KubeconfigFile)
+	if err != nil {
+		return ""
+	}
+	for _, context := range kc.Contexts {
+		if context.Name == c.Context.ContextName {
+			return context.Context.User
+		}
+	}
+	return ""
+}
+
+// Labels for every object created for the service account
+// Called by newTemplateData
+func (sa ServiceAccount) ownerLabels() map[string]string {
+	return map[string]string{
+		ManagedByLabel:               ManagedByValue,
+		ServiceAccountNamespaceLabel: labelValue(sa.Namespace),
+		ServiceAccountNameLabel:      labelValue(sa.ServiceAccountName),
+	}
+}
+
+// OwnerSelector : Label selector for the objects labelled for the service account
+func (sa ServiceAccount) OwnerSelector() string {
+	return ServiceAccountNamespaceLabel + "=" + labelValue(sa.Namespace) + "," + ServiceAccountNameLabel + "=" + labelValue(sa.ServiceAccountName)
+}
+
+// Whether an object's labels are the ownerLabels of the service account
+func (sa ServiceAccount) ownsLabels(labels map[string]string) bool {
+	return labels[ServiceAccountNamespaceLabel] == labelValue(sa.Namespace) && labels[ServiceAccountNameLabel] == labelValue(sa.ServiceAccountName)
+}
+
+// Label values can be at most 63 characters, but service account names up to 253
+const maxLabelValue = 63
+
+// A name as a label value: as is if it fits, otherwise its start and a hash of all of it, which still
+// tells service accounts apart
+func labelValue(name string) string {
+	if len(name) <= maxLabelValue {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	hash := hex.EncodeToString(sum[:])[:10]
+	// Label values must start and end with a letter or digit
+	return strings.TrimRight(name[:maxLabelValue-len(hash)-1], "-.") + "-" + hash
+}
+
+// Annotations for every object created for the service account, from its Provenance
+// Called by newTemplateData
+func (sa ServiceAccount) ownerAnnotations() map[string]string {
+	annotations := map[string]string{}
+	set := func(key string, value string) {
+		if value != "" {
+			annotations[key] = value
+		}
+	}
+	set(AppliedByAnnotation, sa.Provenance.User)
+	if !sa.Provenance.Time.IsZero() {
+		set(AppliedAtAnnotation, sa.Provenance.Time.Format(time.RFC3339))
+	}
+	set(VersionAnnotation, sa.Provenance.Version)
+	set(CommandAnnotation, sa.Provenance.Command)
+	return annotations
+}
@@ -0,0 +1,219 @@
+package k8s
+
+import (
+	"errors"
+)
+
+// Role : What the ServiceAccount is allowed to do, cluster-wide or in each target namespace
+type Role string
+
+const (
+	// ClusterAdminRole : Everything (the default)
+	ClusterAdminRole Role = "cluster-admin"
+	// SpinnakerDeployerRole : Only what Clouddriver's Kubernetes provider needs to deploy workloads
+	SpinnakerDeployerRole Role = "spinnaker-deployer"
+	// ReadOnlyRole : Only get/list/watch, for accounts Spinnaker must never deploy with
+	ReadOnlyRole Role = "read-only"
+)
+
+// Roles : All role presets, in the order they are offered
+var Roles = []Role{ClusterAdminRole, SpinnakerDeployerRole, ReadOnlyRole}
+
+// ParseRole : Validates a role preset given on the command line ("" means not chosen yet)
+func ParseRole(s string) (Role, error) {
+	if s == "" {
+		return "", nil
+	}
+	for _, r := range Roles {
+		if Role(s) == r {
+			return r, nil
+		}
+	}
+	return "", errors.New("unknown role " + s + ", must be one of cluster-admin, spinnaker-deployer, read-only")
+}
+
+// Description : What a role preset allows, for prompts
+func (r Role) Description() string {
+	switch r {
+	case ClusterAdminRole:
+		return "full access to everything"
+	case SpinnakerDeployerRole:
+		return "read cluster-scoped resources, manage workloads"
+	case ReadOnlyRole:
+		return "read cluster-scoped resources and workloads, never deploy"
+	}
+	return ""
+}
+
+// NamespaceBinding : How the ServiceAccount is granted access to each of its target namespaces
+type NamespaceBinding string
+
+const (
+	// RolePerNamespace : A Role with the rules of the role preset in each target namespace (the default)
+	RolePerNamespace NamespaceBinding = "role"
+	// SharedClusterRole : A single ClusterRole with the rules of the role preset, bound in each target namespace
+	SharedClusterRole NamespaceBinding = "shared-cluster-role"
+	// EditBinding : The built-in edit ClusterRole, bound in each target namespace
+	EditBinding NamespaceBinding = "edit"
+	// AdminBinding : The built-in admin ClusterRole, bound in each target namespace
+	AdminBinding NamespaceBinding = "admin"
+	// ViewBinding : The built-in view ClusterRole, bound in each target namespace
+	ViewBinding NamespaceBinding = "view"
+)
+
+// NamespaceBindings : All ways of granting access to target namespaces, in the order they are offered
+var NamespaceBindings = []NamespaceBinding{RolePerNamespace, SharedClusterRole, EditBinding, AdminBinding, ViewBinding}
+
+// ParseNamespaceBinding : Validates a namespace binding given on the command line ("" means not chosen yet)
+func ParseNamespaceBinding(s string) (NamespaceBinding, error) {
+	if s == "" {
+		return "", nil
+	}
+	for _, b := range NamespaceBindings {
+		if NamespaceBinding(s) == b {
+			return b, nil
+		}
+	}
+	return "", errors.New("unknown namespace binding " + s + ", must be one of role, shared-cluster-role, edit, admin, view")
+}
+
+// Description : How a namespace binding grants access, for prompts
+func (b NamespaceBinding) Description() string {
+	switch b {
+	case RolePerNamespace:
+		return "a Role with the role preset's rules in each namespace"
+	case SharedClusterRole:
+		return "one ClusterRole with the role preset's rules, bound in each namespace"
+	case EditBinding, AdminBinding, ViewBinding:
+		return "the built-in " + string(b) + " ClusterRole, bound in each namespace"
+	}
+	return ""
+}
+
+// BuiltIn : Whether the binding is to one of the built-in ClusterRoles, which ignore the role preset
+func (b NamespaceBinding) BuiltIn() bool {
+	return b == EditBinding || b == AdminBinding || b == ViewBinding
+}
+
+// NamespaceRoleRef : Kind and name of the Role or ClusterRole bound in each target namespace
+func (sa ServiceAccount) NamespaceRoleRef() (string, string) {
+	switch {
+	case sa.NamespaceBinding == SharedClusterRole:
+		return "ClusterRole", sa.Namespace + "-" + sa.ServiceAccountName + "-shared"
+	case sa.NamespaceBinding.BuiltIn():
+		return "ClusterRole", string(sa.NamespaceBinding)
+	}
+	return "Role", sa.Namespace + "-" + sa.ServiceAccountName + "-local-admin"
+}
+
+// NamespaceRoleBindingName : Name of the RoleBinding in each target namespace
+func (sa ServiceAccount) NamespaceRoleBindingName() string {
+	return sa.Namespace + "-" + sa.ServiceAccountName + "-binding"
+}
+
+// Name of the ClusterRole, and of the ClusterRoleBinding granting it, for a role preset granted cluster-wide
+func (sa ServiceAccount) presetClusterRoleName(role Role) string {
+	return sa.Namespace + "-" + sa.ServiceAccountName + "-" + string(role)
+}
+
+// Names of the ClusterRoleBindings CreateServiceAccount may create for the service account: -admin
+// binding cluster-admin, or one per other role preset
+func (sa ServiceAccount) clusterRoleBindingNames() []string {
+	names := []string{sa.Namespace + "-" + sa.ServiceAccountName + "-admin"}
+	for _, role := range Roles {
+		if role != ClusterAdminRole {
+			names = append(names, sa.presetClusterRoleName(role))
+		}
+	}
+	return names
+}
+
+// PolicyRule : An RBAC rule
+type PolicyRule struct {
+	APIGroups []string `json:"apiGroups"`
+	Resources []string `json:"resources"`
+	Verbs     []string `json:"verbs"`
+	// Only in rules read from the cluster (see DescribeServiceAccount); the role presets don't use them
+	ResourceNames   []string `json:"resourceNames,omitempty"`
+	NonResourceURLs []string `json:"nonResourceURLs,omitempty"`
+}
+
+var readVerbs = []string{"get", "list", "watch"}
+var allVerbs = []string{"get", "list", "watch", "create", "update", "patch", "delete", "deletecollection"}
+
+// Cluster-scoped kinds Clouddriver reads (for caching, and to list namespaces)
+var clusterReadRules = []PolicyRule{
+	{APIGroups: []string{""}, Resources: []string{"namespaces", "nodes", "persistentvolumes"}, Verbs: readVerbs},
+	{APIGroups: []string{"storage.k8s.io"}, Resources: []string{"storageclasses"}, Verbs: readVerbs},
+	{APIGroups: []string{"apiextensions.k8s.io"}, Resources: []string{"customresourcedefinitions"}, Verbs: readVerbs},
+	{APIGroups: []string{"rbac.authorization.k8s.io"}, Resources: []string{"clusterroles", "clusterrolebindings"}, Verbs: readVerbs},
+}
+
+// Namespaced kinds Spinnaker deploys and manages
+// Creating pods (directly, or through any of the workloads) lets the service account mount any Secret
+// in the namespace, so leaving out secretRules keeps it from reading Secrets through the API, not
+// from getting at them at all
+var workloadRules = []PolicyRule{
+	// Logs can only be read
+	{APIGroups: []string{""}, Resources: []string{"pods/log"}, Verbs: readVerbs},
+	{APIGroups: []string{""}, Resources: []string{"pods", "services", "endpoints", "configmaps", "persistentvolumeclaims", "serviceaccounts"}, Verbs: allVerbs},
+	{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: readVerbs},
+	{APIGroups: []string{"events.k8s.io"}, Resources: []string{"events"}, Verbs: readVerbs},
+	{APIGroups: []string{"apps"}, Resources: []string{"deployments", "deployments/scale", "replicasets", "replicasets/scale", "statefulsets", "statefulsets/scale", "daemonsets", "controllerrevisions"}, Verbs: allVerbs},
+	{APIGroups: []string{"batch"}, Resources: []string{"jobs", "cronjobs"}, Verbs: allVerbs},
+	{APIGroups: []string{"autoscaling"}, Resources: []string{"horizontalpodautoscalers"}, Verbs: allVerbs},
+	{APIGroups: []string{"networking.k8s.io"}, Resources: []string{"ingresses", "networkpolicies"}, Verbs: allVerbs},
+	{APIGroups: []string{"policy"}, Resources: []string{"poddisruptionbudgets"}, Verbs: allVerbs},
+}
+
+var secretRules = []PolicyRule{
+	{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: allVerbs},
+}
+
+var allRules = []PolicyRule{
+	{APIGroups: []string{"*"}, Resources: []string{"*"}, Verbs: []string{"*"}},
+}
+
+// ClusterRules : The rules the service account gets cluster-wide (when it has no target namespaces)
+// Not used for cluster-admin, which is bound to the built-in ClusterRole instead
+func (sa ServiceAccount) ClusterRules() []PolicyRule {
+	rules := append([]PolicyRule{}, clusterReadRules...)
+	return append(rules, sa.NamespaceRules()...)
+}
+
+// NamespaceRules : The rules the service account gets in each target namespace
+func (sa ServiceAccount) NamespaceRules() []PolicyRule {
+	if sa.Role == "" || sa.Role == ClusterAdminRole {
+		return allRules
+	}
+	rules := append([]PolicyRule{}, workloadRules...)
+	if sa.AllowSecrets {
+		rules = append(rules, secretRules...)
+	}
+	if sa.Role == ReadOnlyRole {
+		return withVerbs(rules, readVerbs)
+	}
+	return rules
+}
+
+// Copy of the rules, granting only the given verbs
+func withVerbs(rules []PolicyRule, verbs []string) []PolicyRule {
+	r := make([]PolicyRule, len(rules))
+	for i, rule := range rules {
+		r[i] = PolicyRule{APIGroups: rule.APIGroups, Resources: rule.Resources, Verbs: verbs}
+	}
+	return r
+}
+
+// Namespaced : Whether the service account is only granted access to target namespaces, rather than cluster-wide
+func (sa ServiceAccount) Namespaced() bool {
+	return len(sa.TargetNamespaces) != 0 || sa.TargetNamespaceSelector != ""
+}
+
+// ReadOnly : Whether the service account can only read
+func (sa ServiceAccount) ReadOnly() bool {
+	if sa.Namespaced() && sa.NamespaceBinding == ViewBinding {
+		return true
+	}
+	return sa.Role == ReadOnlyRole
+}
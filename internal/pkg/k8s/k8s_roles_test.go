@@ -0,0 +1,136 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRole(t *testing.T) {
+	r, err := ParseRole("spinnaker-deployer")
+	assert.Nil(t, err)
+	assert.Equal(t, SpinnakerDeployerRole, r)
+
+	r, err = ParseRole("")
+	assert.Nil(t, err)
+	assert.Equal(t, Role(""), r)
+
+	_, err = ParseRole("admin")
+	assert.NotNil(t, err)
+}
+
+func TestNamespaceRulesSecrets(t *testing.T) {
+	sa := ServiceAccount{Role: SpinnakerDeployerRole}
+	assert.False(t, grantsResource(sa.NamespaceRules(), "secrets"))
+	assert.True(t, grantsResource(sa.NamespaceRules(), "deployments"))
+
+	sa.AllowSecrets = true
+	assert.True(t, grantsResource(sa.NamespaceRules(), "secrets"))
+
+	sa.Role = ClusterAdminRole
+	assert.Equal(t, allRules, sa.NamespaceRules())
+}
+
+func TestNamespaceRulesPodLogs(t *testing.T) {
+	sa := ServiceAccount{Role: SpinnakerDeployerRole}
+	for _, r := range sa.NamespaceRules() {
+		for _, resource := range r.Resources {
+			if resource == "pods/log" {
+				assert.Equal(t, readVerbs, r.Verbs)
+			}
+		}
+	}
+	assert.True(t, grantsResource(sa.NamespaceRules(), "pods/log"))
+}
+
+func TestClusterRulesReadClusterScoped(t *testing.T) {
+	sa := ServiceAccount{Role: SpinnakerDeployerRole}
+	rules := sa.ClusterRules()
+	assert.True(t, grantsResource(rules, "namespaces"))
+	for _, r := range rules {
+		for _, resource := range r.Resources {
+			if resource == "namespaces" {
+				assert.Equal(t, readVerbs, r.Verbs)
+			}
+		}
+	}
+}
+
+func TestPresetClusterRoleManifest(t *testing.T) {
+	sa := ServiceAccount{Namespace: "spinnaker", ServiceAccountName: "deployer", Role: SpinnakerDeployerRole}
+	manifest, err := presetClusterRole(sa, false)
+	assert.Nil(t, err)
+	assert.Contains(t, manifest, "name: spinnaker-deployer-spinnaker-deployer\n")
+	assert.Contains(t, manifest, `- apiGroups: ["apps"]`)
+	assert.Contains(t, manifest, `  verbs: ["get","list","watch"]`)
+	assert.NotContains(t, manifest, "secrets")
+}
+
+func grantsResource(rules []PolicyRule, resource string) bool {
+	for _, r := range rules {
+		for _, res := range r.Resources {
+			if res == resource {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestReadOnlyRules(t *testing.T) {
+	sa := ServiceAccount{Role: ReadOnlyRole}
+	assert.True(t, sa.ReadOnly())
+	assert.False(t, grantsResource(sa.NamespaceRules(), "secrets"))
+	for _, r := range sa.ClusterRules() {
+		assert.Equal(t, readVerbs, r.Verbs)
+	}
+
+	// Opting in to secrets still only allows reading them
+	sa.AllowSecrets = true
+	assert.True(t, grantsResource(sa.NamespaceRules(), "secrets"))
+	for _, r := range sa.NamespaceRules() {
+		assert.Equal(t, readVerbs, r.Verbs)
+	}
+
+	// The deployer rules are not modified
+	assert.Equal(t, allVerbs, workloadRules[1].Verbs)
+}
+
+func TestNamespaceRoleBindingReadOnly(t *testing.T) {
+	sa := ServiceAccount{Namespace: "spinnaker", ServiceAccountName: "viewer", Role: ReadOnlyRole}
+	manifest, err := namespaceRoleBinding(sa, "apps", false)
+	assert.Nil(t, err)
+	assert.NotContains(t, manifest, `verbs: ["*"]`)
+	assert.Contains(t, manifest, `  verbs: ["get","list","watch"]`)
+}
+
+func TestNamespaceRoleBindingSharedClusterRole(t *testing.T) {
+	sa := ServiceAccount{Namespace: "spinnaker", ServiceAccountName: "deployer", Role: SpinnakerDeployerRole,
+		TargetNamespaces: []string{"apps"}, NamespaceBinding: SharedClusterRole}
+	manifest, err := namespaceRoleBinding(sa, "apps", false)
+	assert.Nil(t, err)
+	assert.NotContains(t, manifest, "kind: Role\n")
+	assert.Contains(t, manifest, "  kind: ClusterRole\n  name: spinnaker-deployer-shared\n")
+
+	manifest, err = sharedClusterRole(sa, false)
+	assert.Nil(t, err)
+	assert.Contains(t, manifest, "name: spinnaker-deployer-shared\n")
+	assert.Contains(t, manifest, `- apiGroups: ["apps"]`)
+
+	manifests, err := ServiceAccountManifests(sa, false)
+	assert.Nil(t, err)
+	assert.Len(t, manifests, 3)
+}
+
+func TestNamespaceRoleBindingBuiltIn(t *testing.T) {
+	sa := ServiceAccount{Namespace: "spinnaker", ServiceAccountName: "viewer",
+		TargetNamespaces: []string{"apps"}, NamespaceBinding: ViewBinding}
+	assert.True(t, sa.ReadOnly())
+	manifest, err := namespaceRoleBinding(sa, "apps", false)
+	assert.Nil(t, err)
+	assert.NotContains(t, manifest, "rules:")
+	assert.Contains(t, manifest, "  kind: ClusterRole\n  name: view\n")
+
+	_, err = ParseNamespaceBinding("cluster-admin")
+	assert.NotNil(t, err)
+}
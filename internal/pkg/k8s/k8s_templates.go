@@ -1,88 +1,411 @@
 package k8s
 
 import (
-  "bytes"
-  "fmt"
-  "text/template"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v2"
 )
 
-func buildKubeconfig(sac serviceAccountContext, verbose bool) (string, string, error) {
-  var tpl bytes.Buffer
-
-  t, err := template.New("KubeconfigTemplate").Parse(
-    `apiVersion: v1
-clusters:
-- cluster:
-    certificate-authority-data: {{ .CA }}
-    server: {{ .Server }}
-  name: {{ .Alias }}
-contexts:
-- context:
-    cluster: {{ .Alias }}
-    user: {{ .Alias }}
-  name: {{ .Alias }}
-current-context: {{ .Alias }}
-kind: Config
-preferences: {}
-users:
-- name: {{ .Alias }}
-  user:
-    token: {{ .Token }}
-`)
-  if err != nil {
-    fmt.Println(err)
-    fmt.Println("TODO error handling1")
-    return "", "Failed to Template", err
-  }
-
-  err = t.Execute(&tpl, sac)
-  if err != nil {
-    fmt.Println(err)
-    fmt.Println("TODO error handling2")
-    return "", "Failed to execute template", err
-  }
-
-  return tpl.String(), "", nil
-}
-
-// Service account only
-func serviceAccountDefinition(sa ServiceAccount, verbose bool) string {
-  var tpl bytes.Buffer
-
-  t, err := template.New("ServiceAccountManifest").Parse(
-    `---
+// TemplateOptions : Overrides for the manifest templates used to create the ServiceAccount and its RBAC
+type TemplateOptions struct {
+	// Directory with <template name>.yaml files replacing built-in templates, for example
+	// namespaceRoleBinding.yaml (see OverridableTemplates)
+	Dir string
+	// Custom values, available in templates as {{ .Values.<key> }}
+	Values map[string]string
+}
+
+// OverridableTemplates : Names of the templates that can be replaced through TemplateOptions.Dir
+var OverridableTemplates = []string{
+	"namespaceDefinition",
+	"serviceAccountDefinition",
+	"serviceAccountTokenSecret",
+	"adminClusterRoleBinding",
+	"presetClusterRole",
+	"sharedClusterRole",
+	"namespaceRoleBinding",
+}
+
+// ParseTemplateValues : Parses key=value pairs (from --set) into TemplateOptions.Values
+func ParseTemplateValues(pairs []string) (map[string]string, error) {
+	values := map[string]string{}
+	for _, pair := range pairs {
+		i := strings.Index(pair, "=")
+		if i < 1 {
+			return nil, errors.New("invalid template value `" + pair + "`, must be key=value")
+		}
+		values[pair[:i]] = pair[i+1:]
+	}
+	return values, nil
+}
+
+// templateData : What the service account templates can use
+// Fields of the ServiceAccount (such as .Namespace and .ServiceAccountName) are available directly
+type templateData struct {
+	ServiceAccount
+	// The target namespace, or for namespaceDefinition, the namespace being created
+	Target      string
+	RoleKind    string
+	RoleName    string
+	BindingName string
+	Rules       []PolicyRule
+	Values      map[string]string
+	// Labels and annotations identifying the tool and service account, for every object (see
+	// k8s_metadata.go), plus any particular to the object; rendered by {{ ownerMetadata . }}
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+func newTemplateData(sa ServiceAccount) templateData {
+	values := sa.Templates.Values
+	if values == nil {
+		values = map[string]string{}
+	}
+	return templateData{
+		ServiceAccount: sa,
+		Values:         values,
+		Labels:         sa.ownerLabels(),
+		Annotations:    sa.ownerAnnotations(),
+	}
+}
+
+// Functions available in manifest templates
+var templateFuncs = template.FuncMap{
+	"quote":         quote,
+	"quoteList":     quoteList,
+	"ownerMetadata": ownerMetadata,
+}
+
+// Renders a value as a YAML (JSON) quoted string
+func quote(v interface{}) string {
+	b, _ := json.Marshal(fmt.Sprint(v))
+	return string(b)
+}
+
+// Renders a list of strings as a YAML flow sequence of quoted strings, e.g. ["", "apps"]
+func quoteList(l []string) string {
+	b, _ := json.Marshal(l)
+	return string(b)
+}
+
+// Template data with the labels and annotations of an object created for a service account
+type ownedObject interface {
+	metadata() (map[string]string, map[string]string)
+}
+
+func (data templateData) metadata() (map[string]string, map[string]string) {
+	return data.Labels, data.Annotations
+}
+
+// Renders the labels and annotations of an object created for the service account (the lines under
+// metadata:), from .Labels and .Annotations
+func ownerMetadata(data ownedObject) string {
+	labels, annotations := data.metadata()
+	var lines []string
+	for _, field := range []struct {
+		name   string
+		values map[string]string
+	}{{"labels", labels}, {"annotations", annotations}} {
+		if len(field.values) == 0 {
+			continue
+		}
+		var keys []string
+		for key := range field.values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		lines = append(lines, "  "+field.name+":")
+		for _, key := range keys {
+			lines = append(lines, "    "+key+": "+quote(field.values[key]))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Renders a manifest template, and checks that the result is valid YAML describing Kubernetes objects
+// If opts.Dir holds a <name>.yaml file, it is used instead of the built-in template
+func renderManifest(name string, builtin string, opts TemplateOptions, data interface{}) (string, error) {
+	text := builtin
+	if opts.Dir != "" {
+		b, err := ioutil.ReadFile(filepath.Join(opts.Dir, name+".yaml"))
+		if err == nil {
+			text = string(b)
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+
+	t, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid template %s: %v", name, err)
+	}
+
+	var tpl bytes.Buffer
+	if err := t.Execute(&tpl, data); err != nil {
+		return "", fmt.Errorf("unable to render template %s: %v", name, err)
+	}
+
+	if err := validateManifest(tpl.String()); err != nil {
+		return "", fmt.Errorf("template %s rendered an invalid manifest: %v", name, err)
+	}
+	return tpl.String(), nil
+}
+
+// Checks that every document in a (multi-document) YAML manifest is a Kubernetes object
+func validateManifest(manifest string) error {
+	objects, err := ManifestObjects(manifest)
+	if err != nil {
+		return err
+	}
+	for i, doc := range objects {
+		for _, field := range []string{"apiVersion", "kind"} {
+			if s, ok := doc[field].(string); !ok || s == "" {
+				return fmt.Errorf("document %d has no %s", i+1, field)
+			}
+		}
+		metadata, ok := doc["metadata"].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("document %d has no metadata", i+1)
+		}
+		if s, ok := metadata["name"].(string); !ok || s == "" {
+			return fmt.Errorf("document %d has no metadata.name", i+1)
+		}
+	}
+	return nil
+}
+
+// ManifestObjects : Decodes the objects in a (multi-document) YAML manifest, skipping empty documents
+// Maps are keyed by strings, as when decoding JSON, so objects can be compared with those returned by
+// the API server, or encoded as JSON
+func ManifestObjects(manifest string) ([]map[string]interface{}, error) {
+	var objects []map[string]interface{}
+	decoder := yaml.NewDecoder(strings.NewReader(manifest))
+	for {
+		var doc interface{}
+		err := decoder.Decode(&doc)
+		if err == io.EOF {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if doc == nil {
+			continue
+		}
+		m, ok := jsonValue(doc).(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("document %d is not an object", len(objects)+1)
+		}
+		objects = append(objects, m)
+	}
+}
+
+// Converts YAML maps (which can have non-string keys) to maps keyed by strings
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = jsonValue(value)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, value := range v {
+			l[i] = jsonValue(value)
+		}
+		return l
+	}
+	return v
+}
+
+// ServiceAccountManifests : Renders every manifest CreateServiceAccount applies for the service account, in order
+// Used to catch broken custom templates before anything is created in the cluster, for --dry-run, and by
+// the controller
+func ServiceAccountManifests(sa ServiceAccount, verbose bool) ([]string, error) {
+	var manifests []string
+	add := func(manifest string, err error) error {
+		if err == nil {
+			manifests = append(manifests, manifest)
+		}
+		return err
+	}
+
+	if sa.newNamespace {
+		if err := add(namespaceDefinition(sa, sa.Namespace, verbose)); err != nil {
+			return nil, err
+		}
+	}
+	for _, target := range sa.newTargetNamespaces {
+		if err := add(namespaceDefinition(sa, target, verbose)); err != nil {
+			return nil, err
+		}
+	}
+	if err := add(serviceAccountDefinition(sa, verbose)); err != nil {
+		return nil, err
+	}
+	if sa.Token.CreateSecret {
+		if err := add(serviceAccountTokenSecret(sa, verbose)); err != nil {
+			return nil, err
+		}
+	}
+	if !sa.Namespaced() && (sa.Role == "" || sa.Role == ClusterAdminRole) {
+		if err := add(adminClusterRoleBinding(sa, verbose)); err != nil {
+			return nil, err
+		}
+	} else if !sa.Namespaced() {
+		if err := add(presetClusterRole(sa, verbose)); err != nil {
+			return nil, err
+		}
+	} else if sa.NamespaceBinding == SharedClusterRole {
+		if err := add(sharedClusterRole(sa, verbose)); err != nil {
+			return nil, err
+		}
+	}
+	for _, target := range sa.TargetNamespaces {
+		if err := add(namespaceRoleBinding(sa, target, verbose)); err != nil {
+			return nil, err
+		}
+	}
+	return manifests, nil
+}
+
+// Returns the YAML manifest for a namespace the service account needs that doesn't exist yet
+func namespaceDefinition(sa ServiceAccount, name string, verbose bool) (string, error) {
+	data := newTemplateData(sa)
+	data.Target = name
+	data.Annotations[CreatedForAnnotation] = sa.Namespace + "/" + sa.ServiceAccountName
+
+	return renderManifest("namespaceDefinition", `---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: {{ .Target }}
+{{ ownerMetadata . }}
+`, sa.Templates, data)
+}
+
+// Service account only, annotated with how it was set up (see k8s_metadata.go)
+func serviceAccountDefinition(sa ServiceAccount, verbose bool) (string, error) {
+	data := newTemplateData(sa)
+	for key, value := range sa.setupAnnotations() {
+		data.Annotations[key] = value
+	}
+
+	return renderManifest("serviceAccountDefinition", `---
 apiVersion: v1
 kind: ServiceAccount
 metadata:
   name: {{ .ServiceAccountName }}
   namespace: {{ .Namespace }}
-`)
-  if err != nil {
-    fmt.Println(err)
-    fmt.Println("TODO error handling1")
-    return ""
-  }
+{{ ownerMetadata . }}
+`, sa.Templates, data)
+}
 
-  err = t.Execute(&tpl, sa)
-  if err != nil {
-    fmt.Println(err)
-    fmt.Println("TODO error handling2")
-    return ""
-  }
+// Returns the YAML manifest for a long-lived token Secret for a service account
+// The token controller populates the token once the Secret exists
+func serviceAccountTokenSecret(sa ServiceAccount, verbose bool) (string, error) {
+	data := newTemplateData(sa)
+	data.Annotations["kubernetes.io/service-account.name"] = sa.ServiceAccountName
 
-  return tpl.String()
+	return renderManifest("serviceAccountTokenSecret", `---
+apiVersion: v1
+kind: Secret
+type: kubernetes.io/service-account-token
+metadata:
+  name: {{ .Token.SecretName }}
+  namespace: {{ .Namespace }}
+{{ ownerMetadata . }}
+`, sa.Templates, data)
 }
 
-// Returns the YAML manifest to bind cluster-admin to a service account
-func adminClusterRoleBinding(sa ServiceAccount, verbose bool) string {
-  var tpl bytes.Buffer
+// Returns the YAML manifest for an Opaque Secret holding a kubeconfig
+func kubeconfigSecret(secret kubeconfigSecretData, verbose bool) (string, error) {
+	return renderManifest("kubeconfigSecret", `---
+apiVersion: v1
+kind: Secret
+type: Opaque
+metadata:
+  name: {{ .Name }}
+  namespace: {{ .Namespace }}
+{{ ownerMetadata . }}
+data:
+  {{ .Key }}: {{ .Data }}
+`, TemplateOptions{}, secret)
+}
+
+// Returns the YAML manifest for a CertificateSigningRequest for a client certificate
+func certificateSigningRequest(csr certificateRequest, verbose bool) (string, error) {
+	return renderManifest("certificateSigningRequest", `---
+apiVersion: certificates.k8s.io/v1
+kind: CertificateSigningRequest
+metadata:
+  name: {{ .Name }}
+spec:
+  request: {{ .Request }}
+  signerName: kubernetes.io/kube-apiserver-client
+{{- if .ExpirationSeconds }}
+  expirationSeconds: {{ .ExpirationSeconds }}
+{{- end }}
+  usages:
+  - client auth
+`, TemplateOptions{}, csr)
+}
 
-  t, err := template.New("ClusterRoleBindingManifest").Parse(
-    `---
+// Returns the YAML manifest for a ClusterRole with the rules of the service account's role preset,
+// and a ClusterRoleBinding granting it to the service account
+func presetClusterRole(sa ServiceAccount, verbose bool) (string, error) {
+	data := newTemplateData(sa)
+	data.RoleName = sa.presetClusterRoleName(sa.Role)
+	data.Rules = sa.ClusterRules()
+
+	return renderManifest("presetClusterRole", `---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: {{ .RoleName }}
+{{ ownerMetadata . }}
+rules:
+{{- range .Rules }}
+- apiGroups: {{ quoteList .APIGroups }}
+  resources: {{ quoteList .Resources }}
+  verbs: {{ quoteList .Verbs }}
+{{- end }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: {{ .RoleName }}
+{{ ownerMetadata . }}
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: {{ .RoleName }}
+subjects:
+- kind: ServiceAccount
+  name: {{ .ServiceAccountName }}
+  namespace: {{ .Namespace }}
+`, sa.Templates, data)
+}
+
+// Returns the YAML manifest to bind cluster-admin to a service account
+func adminClusterRoleBinding(sa ServiceAccount, verbose bool) (string, error) {
+	return renderManifest("adminClusterRoleBinding", `---
 apiVersion: rbac.authorization.k8s.io/v1
 kind: ClusterRoleBinding
 metadata:
   name: {{ .Namespace }}-{{ .ServiceAccountName }}-admin
+{{ ownerMetadata . }}
 roleRef:
   apiGroup: rbac.authorization.k8s.io
   kind: ClusterRole
@@ -91,79 +414,71 @@ subjects:
 - kind: ServiceAccount
   name: {{ .ServiceAccountName }}
   namespace: {{ .Namespace }}
-`)
-  if err != nil {
-    fmt.Println(err)
-    fmt.Println("TODO error handling1")
-    return ""
-  }
+`, sa.Templates, newTemplateData(sa))
+}
 
-  err = t.Execute(&tpl, sa)
-  if err != nil {
-    fmt.Println(err)
-    fmt.Println("TODO error handling2")
-    return ""
-  }
+// Returns the YAML manifest for the ClusterRole (with the rules of the service account's role preset)
+// bound in each target namespace, when they share one
+func sharedClusterRole(sa ServiceAccount, verbose bool) (string, error) {
+	data := newTemplateData(sa)
+	data.RoleKind, data.RoleName = sa.NamespaceRoleRef()
+	data.Rules = sa.NamespaceRules()
 
-  return tpl.String()
+	return renderManifest("sharedClusterRole", `---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: {{ .RoleName }}
+{{ ownerMetadata . }}
+rules:
+{{- range .Rules }}
+- apiGroups: {{ quoteList .APIGroups }}
+  resources: {{ quoteList .Resources }}
+  verbs: {{ quoteList .Verbs }}
+{{- end }}
+`, sa.Templates, data)
 }
 
-// Returns the YAML manifest to bind cluster-admin to a service account
-// Includes namespace, role, and binding
-func namespaceRoleBinding(sa ServiceAccount, target string, verbose bool) string {
-  var tpl bytes.Buffer
-
-  binding := map[string]string{
-    "Namespace":           sa.Namespace,
-    "ServiceAccountName":  sa.ServiceAccountName,
-    "Target":              target,
-    "RoleName":            sa.Namespace + "-" + sa.ServiceAccountName + "-local-admin",
-    "BindingName":         sa.Namespace + "-" + sa.ServiceAccountName + "-binding",
-  }
-
-  t, err := template.New("ClusterRoleBindingManifest").Parse(
-    `---
-apiVersion: v1
-kind: Namespace
-metadata:
-  name: {{ .Target }}
+// Returns the YAML manifest to grant a service account access to a target namespace
+// Includes role (with the rules of the service account's role preset, unless a
+// ClusterRole is bound instead), and binding
+func namespaceRoleBinding(sa ServiceAccount, target string, verbose bool) (string, error) {
+	data := newTemplateData(sa)
+	data.Target = target
+	data.RoleKind, data.RoleName = sa.NamespaceRoleRef()
+	data.BindingName = sa.NamespaceRoleBindingName()
+	data.Rules = sa.NamespaceRules()
+
+	return renderManifest("namespaceRoleBinding", `
+{{- if eq .RoleKind "Role" }}
 ---
 apiVersion: rbac.authorization.k8s.io/v1
 kind: Role
 metadata:
   name: {{ .RoleName }}
   namespace: {{ .Target }}
+{{ ownerMetadata . }}
 rules:
-- apiGroups: ["*"]
-  resources: ["*"]
-  verbs: ["*"]
+{{- range .Rules }}
+- apiGroups: {{ quoteList .APIGroups }}
+  resources: {{ quoteList .Resources }}
+  verbs: {{ quoteList .Verbs }}
+{{- end }}
+{{- end }}
 ---
 apiVersion: rbac.authorization.k8s.io/v1
 kind: RoleBinding
 metadata:
   name: {{ .BindingName }}
   namespace: {{ .Target }}
+{{ ownerMetadata . }}
 roleRef:
   apiGroup: rbac.authorization.k8s.io
-  kind: Role
+  kind: {{ .RoleKind }}
   name: {{ .RoleName }}
 subjects:
 - namespace: {{ .Namespace }}
   kind: ServiceAccount
   name: {{ .ServiceAccountName }}
-`)
-  if err != nil {
-    fmt.Println(err)
-    fmt.Println("TODO error handling1")
-    return ""
-  }
-
-  err = t.Execute(&tpl, binding)
-  if err != nil {
-    fmt.Println(err)
-    fmt.Println("TODO error handling2")
-    return ""
-  }
-
-  return tpl.String()
-}
\ No newline at end of file
+`, sa.Templates, data)
+}
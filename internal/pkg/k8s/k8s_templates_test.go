@@ -0,0 +1,153 @@
+package k8s
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestParseTemplateValues(t *testing.T) {
+	values, err := ParseTemplateValues([]string{"team=payments", "owner=a=b", "empty="})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"team": "payments", "owner": "a=b", "empty": ""}, values)
+
+	_, err = ParseTemplateValues([]string{"=value"})
+	assert.NotNil(t, err)
+	_, err = ParseTemplateValues([]string{"novalue"})
+	assert.NotNil(t, err)
+}
+
+func TestTemplateOverride(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "serviceAccountDefinition.yaml"), []byte(`apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: {{ .ServiceAccountName }}
+  namespace: {{ .Namespace }}
+  labels:
+    team: {{ .Values.team }}
+`), 0644)
+	assert.Nil(t, err)
+
+	sa := ServiceAccount{
+		Namespace:          "spinnaker",
+		ServiceAccountName: "deployer",
+		Templates:          TemplateOptions{Dir: dir, Values: map[string]string{"team": "payments"}},
+	}
+	manifest, err := serviceAccountDefinition(sa, false)
+	assert.Nil(t, err)
+	assert.Contains(t, manifest, "team: payments")
+
+	// Templates without an override use the built-in one
+	manifest, err = adminClusterRoleBinding(sa, false)
+	assert.Nil(t, err)
+	assert.Contains(t, manifest, "name: spinnaker-deployer-admin")
+
+	// Values that weren't set are an error rather than an empty string
+	sa.Templates.Values = nil
+	_, err = serviceAccountDefinition(sa, false)
+	assert.NotNil(t, err)
+}
+
+func TestValidateManifest(t *testing.T) {
+	assert.Nil(t, validateManifest("---\napiVersion: v1\nkind: Namespace\nmetadata:\n  name: apps\n---\n"))
+	assert.NotNil(t, validateManifest("apiVersion: v1\nkind: Namespace\nmetadata: {}\n"))
+	assert.NotNil(t, validateManifest("kind: Namespace\nmetadata:\n  name: apps\n"))
+	assert.NotNil(t, validateManifest("apiVersion: v1\nkind: [Namespace\n"))
+}
+
+func TestManifestObjects(t *testing.T) {
+	objects, err := ManifestObjects("---\n---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\ndata:\n  1: one\n---\nkind: Namespace\n")
+	assert.Nil(t, err)
+	assert.Equal(t, []map[string]interface{}{
+		{"apiVersion": "v1", "kind": "ConfigMap", "metadata": map[string]interface{}{"name": "a"}, "data": map[string]interface{}{"1": "one"}},
+		{"kind": "Namespace"},
+	}, objects)
+
+	_, err = ManifestObjects("- a\n- b\n")
+	assert.NotNil(t, err)
+}
+
+func TestOwnershipMetadata(t *testing.T) {
+	sa := ServiceAccount{
+		Namespace:           "spinnaker",
+		ServiceAccountName:  "deployer",
+		Role:                SpinnakerDeployerRole,
+		TargetNamespaces:    []string{"apps"},
+		NamespaceBinding:    SharedClusterRole,
+		newNamespace:        true,
+		newTargetNamespaces: []string{"apps"},
+		Token:               TokenOptions{CreateSecret: true, SecretName: "deployer-token"},
+		Provenance: Provenance{
+			User:    "jane@example.com",
+			Time:    time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+			Version: "1.2.3",
+			Command: "spinnaker-tools create-service-account",
+		},
+	}
+	manifests, err := ServiceAccountManifests(sa, false)
+	assert.Nil(t, err)
+
+	kinds := 0
+	decoder := yaml.NewDecoder(strings.NewReader(strings.Join(manifests, "")))
+	for {
+		var doc struct {
+			Kind     string `yaml:"kind"`
+			Metadata struct {
+				Labels      map[string]string `yaml:"labels"`
+				Annotations map[string]string `yaml:"annotations"`
+			} `yaml:"metadata"`
+		}
+		err := decoder.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		kinds++
+		assert.Equal(t, ManagedByValue, doc.Metadata.Labels[ManagedByLabel], doc.Kind)
+		assert.Equal(t, "spinnaker", doc.Metadata.Labels[ServiceAccountNamespaceLabel], doc.Kind)
+		assert.Equal(t, "deployer", doc.Metadata.Labels[ServiceAccountNameLabel], doc.Kind)
+		assert.Equal(t, "jane@example.com", doc.Metadata.Annotations[AppliedByAnnotation], doc.Kind)
+		assert.Equal(t, "2024-05-01T12:00:00Z", doc.Metadata.Annotations[AppliedAtAnnotation], doc.Kind)
+		assert.Equal(t, "1.2.3", doc.Metadata.Annotations[VersionAnnotation], doc.Kind)
+		assert.Equal(t, "spinnaker-tools create-service-account", doc.Metadata.Annotations[CommandAnnotation], doc.Kind)
+	}
+	// 2 Namespaces, ServiceAccount, Secret, ClusterRole, RoleBinding
+	assert.Equal(t, 6, kinds)
+
+	// Without a provenance, only the labels are set
+	sa.Provenance = Provenance{}
+	manifest, err := sharedClusterRole(sa, false)
+	assert.Nil(t, err)
+	assert.NotContains(t, manifest, "annotations:")
+	assert.Nil(t, validateManifest(manifest))
+}
+
+func TestOwnerLabelsLongName(t *testing.T) {
+	sa := ServiceAccount{Namespace: "spinnaker", ServiceAccountName: strings.Repeat("deployer.", 28) + "prod"}
+	labels := sa.ownerLabels()
+	name := labels[ServiceAccountNameLabel]
+	assert.Len(t, name, maxLabelValue)
+	assert.True(t, strings.HasPrefix(name, "deployer.deployer."))
+	assert.True(t, sa.ownsLabels(labels))
+	assert.Contains(t, sa.OwnerSelector(), ServiceAccountNameLabel+"="+name)
+
+	// Names that share the start are still told apart
+	other := ServiceAccount{Namespace: "spinnaker", ServiceAccountName: strings.Repeat("deployer.", 28) + "test"}
+	assert.False(t, other.ownsLabels(labels))
+
+	manifest, err := serviceAccountDefinition(sa, false)
+	assert.Nil(t, err)
+	assert.Contains(t, manifest, "    "+ServiceAccountNameLabel+": \""+name+"\"\n")
+	assert.Equal(t, "spinnaker", labelValue("spinnaker"))
+}
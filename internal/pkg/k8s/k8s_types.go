@@ -1,5 +1,10 @@
 package k8s
 
+import (
+	"errors"
+	"time"
+)
+
 // Cluster : Everything needed to talk to a K8s cluster
 // TODO: Maybe make a constructor so these can be private
 type Cluster struct {
@@ -19,11 +24,99 @@ type ServiceAccount struct {
 	newNamespace       bool
 	ServiceAccountName string
 	newServiceAccount  bool
-	// TODO handle non-cluster-admin service account
-	// Admin bool
+	Role               Role
+	// Whether Roles other than cluster-admin include access to Secrets
+	AllowSecrets     bool
 	TargetNamespaces []string
+	// Label selector for target namespaces; matching namespaces are added to TargetNamespaces by
+	// DefineServiceAccount, and kept up to date by SyncServiceAccount
+	TargetNamespaceSelector string
+	// How access to each target namespace is granted
+	NamespaceBinding NamespaceBinding
+	// What to do about target namespaces that don't exist
+	MissingNamespaces   MissingNamespacePolicy
+	newTargetNamespaces []string
 	// TODO decide if we wanna track existing namespaces
 	// Namespaces       []string
+	Credentials CredentialType
+	Token       TokenOptions
+	Certificate CertificateOptions
+	Templates   TemplateOptions
+	// Recorded in annotations on everything created for the service account
+	Provenance Provenance
+	// Set by DiffServiceAccount; CreateServiceAccount only applies what it says changed
+	diff *ServiceAccountDiff
+}
+
+// CredentialType : What the ServiceAccount authenticates with in the generated kubeconfig
+type CredentialType string
+
+const (
+	// TokenCredentials : A service account token (the default)
+	TokenCredentials CredentialType = "token"
+	// CertificateCredentials : A client certificate, issued through the CertificateSigningRequest API
+	CertificateCredentials CredentialType = "certificate"
+)
+
+// ParseCredentialType : Validates a credential type given on the command line ("" means token)
+func ParseCredentialType(s string) (CredentialType, error) {
+	switch CredentialType(s) {
+	case "", TokenCredentials:
+		return TokenCredentials, nil
+	case CertificateCredentials:
+		return CertificateCredentials, nil
+	}
+	return "", errors.New("unknown credential type " + s + ", must be one of token, certificate")
+}
+
+// MissingNamespacePolicy : What DefineServiceAccount does about target namespaces that don't exist yet
+type MissingNamespacePolicy string
+
+const (
+	// PromptMissingNamespaces : Ask whether to create them (the default; fails if we can't prompt)
+	PromptMissingNamespaces MissingNamespacePolicy = "prompt"
+	// FailMissingNamespaces : Stop, so a mistyped namespace never creates a new one
+	FailMissingNamespaces MissingNamespacePolicy = "fail"
+	// CreateMissingNamespaces : Create them
+	CreateMissingNamespaces MissingNamespacePolicy = "create"
+)
+
+// ParseMissingNamespacePolicy : Validates a missing namespace policy given on the command line ("" means prompt)
+func ParseMissingNamespacePolicy(s string) (MissingNamespacePolicy, error) {
+	switch MissingNamespacePolicy(s) {
+	case "", PromptMissingNamespaces:
+		return PromptMissingNamespaces, nil
+	case FailMissingNamespaces:
+		return FailMissingNamespaces, nil
+	case CreateMissingNamespaces:
+		return CreateMissingNamespaces, nil
+	}
+	return "", errors.New("unknown missing namespace policy " + s + ", must be one of prompt, fail, create")
+}
+
+// TokenOptions : Where the token for the ServiceAccount comes from
+// Duration and Audiences apply to tokens requested through the TokenRequest API, which is used on
+// clusters that no longer auto-create token Secrets (Kubernetes 1.24+)
+type TokenOptions struct {
+	// Zero leaves the expiration up to the API server
+	Duration  time.Duration
+	Audiences []string
+	// If set, the token is read from this (long-lived) service-account-token Secret
+	SecretName string
+	// Whether CreateServiceAccount should create the SecretName Secret
+	CreateSecret bool
+	// How long WaitForToken waits for a token to become available, and how often it checks
+	Timeout      time.Duration
+	PollInterval time.Duration
+}
+
+// CertificateOptions : How the client certificate for the ServiceAccount is issued
+type CertificateOptions struct {
+	// Requested lifetime of the certificate; zero leaves it up to the signer
+	Duration time.Duration
+	// How long to wait for the CertificateSigningRequest to be approved and signed, and how often to check
+	Timeout      time.Duration
+	PollInterval time.Duration
 }
 
 type namespaceJSON struct {
@@ -47,9 +140,55 @@ type serviceAccountsJSON struct {
 	} `json:"items"`
 }
 
-type serviceAccountContext struct {
-	CA     string
-	Server string
-	Token  string
-	Alias  string
+type serviceAccountJSON struct {
+	Metadata struct {
+		Name        string            `json:"name"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+}
+
+type roleBindingsJSON struct {
+	Items []RoleBindingJSON `json:"items"`
+}
+
+// RoleBindingJSON : A RoleBinding or ClusterRoleBinding, as returned by the API server
+type RoleBindingJSON struct {
+	Metadata struct {
+		Name      string            `json:"name"`
+		Namespace string            `json:"namespace"`
+		Labels    map[string]string `json:"labels"`
+	} `json:"metadata"`
+	RoleRef struct {
+		Kind string `json:"kind"`
+		Name string `json:"name"`
+	} `json:"roleRef"`
+	Subjects []subjectJSON `json:"subjects"`
+}
+
+type subjectJSON struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+type objectsJSON struct {
+	Items []struct {
+		Type     string `json:"type"`
+		Metadata struct {
+			Name        string            `json:"name"`
+			Namespace   string            `json:"namespace"`
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+	} `json:"items"`
+}
+
+type certificateSigningRequestJSON struct {
+	Status struct {
+		Certificate string `json:"certificate"`
+		Conditions  []struct {
+			Type    string `json:"type"`
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"conditions"`
+	} `json:"status"`
 }
@@ -0,0 +1,165 @@
+package k8s
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+// KubeconfigContextName : Name of the only context in generated kubeconfigs
+const KubeconfigContextName = "spinnaker"
+
+// ReadOnlyKubeconfigContextName : Name of the only context in kubeconfigs for read-only service accounts
+const ReadOnlyKubeconfigContextName = "spinnaker-readonly"
+
+// KubeconfigContext : Name of the only context in the kubeconfig generated for the service account
+func KubeconfigContext(sa ServiceAccount) string {
+	if sa.ReadOnly() {
+		return ReadOnlyKubeconfigContextName
+	}
+	return KubeconfigContextName
+}
+
+// kubeconfig : A kubeconfig file
+// Only the fields we read or write are modeled; anything else on clusters and contexts is carried
+// through in Extra so we don't lose settings like proxy-url or tls-server-name
+type kubeconfig struct {
+	APIVersion     string                   `yaml:"apiVersion"`
+	Kind           string                   `yaml:"kind"`
+	Clusters       []kubeconfigNamedCluster `yaml:"clusters"`
+	Contexts       []kubeconfigNamedContext `yaml:"contexts"`
+	CurrentContext string                   `yaml:"current-context"`
+	Preferences    map[string]interface{}   `yaml:"preferences"`
+	Users          []kubeconfigNamedUser    `yaml:"users"`
+}
+
+type kubeconfigNamedCluster struct {
+	Name    string            `yaml:"name"`
+	Cluster kubeconfigCluster `yaml:"cluster"`
+}
+
+type kubeconfigCluster struct {
+	Server                   string                 `yaml:"server"`
+	CertificateAuthority     string                 `yaml:"certificate-authority,omitempty"`
+	CertificateAuthorityData string                 `yaml:"certificate-authority-data,omitempty"`
+	Extra                    map[string]interface{} `yaml:",inline"`
+}
+
+type kubeconfigNamedContext struct {
+	Name    string            `yaml:"name"`
+	Context kubeconfigContext `yaml:"context"`
+}
+
+type kubeconfigContext struct {
+	Cluster   string                 `yaml:"cluster"`
+	User      string                 `yaml:"user"`
+	Namespace string                 `yaml:"namespace,omitempty"`
+	Extra     map[string]interface{} `yaml:",inline"`
+}
+
+type kubeconfigNamedUser struct {
+	Name string         `yaml:"name"`
+	User kubeconfigUser `yaml:"user"`
+}
+
+// Source kubeconfig users are never copied, so only the fields we generate are modeled
+type kubeconfigUser struct {
+	Token                 string `yaml:"token,omitempty"`
+	ClientCertificateData string `yaml:"client-certificate-data,omitempty"`
+	ClientKeyData         string `yaml:"client-key-data,omitempty"`
+}
+
+// Reads and parses a kubeconfig file
+// Called by CreateKubeconfig
+func loadKubeconfig(filename string) (*kubeconfig, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return parseKubeconfig(b)
+}
+
+func parseKubeconfig(b []byte) (*kubeconfig, error) {
+	var kc kubeconfig
+	if err := yaml.Unmarshal(b, &kc); err != nil {
+		return nil, err
+	}
+	return &kc, nil
+}
+
+// Builds a minimal kubeconfig holding only the cluster of the given context, with a single
+// context (named newContextName) that uses the credential in the given namespace
+// Relative certificate-authority paths are resolved against baseDir, and inlined
+// Called by CreateKubeconfig
+func (k *kubeconfig) extract(contextName string, newContextName string, namespace string, cred credential, baseDir string) (*kubeconfig, error) {
+	var context *kubeconfigContext
+	for i := range k.Contexts {
+		if k.Contexts[i].Name == contextName {
+			context = &k.Contexts[i].Context
+		}
+	}
+	if context == nil {
+		return nil, errors.New("context " + contextName + " not found in kubeconfig")
+	}
+
+	var cluster *kubeconfigCluster
+	for i := range k.Clusters {
+		if k.Clusters[i].Name == context.Cluster {
+			c := k.Clusters[i].Cluster
+			cluster = &c
+		}
+	}
+	if cluster == nil {
+		return nil, errors.New("cluster " + context.Cluster + " of context " + contextName + " not found in kubeconfig")
+	}
+
+	if cluster.CertificateAuthority != "" {
+		path := cluster.CertificateAuthority
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, path)
+		}
+		ca, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		cluster.CertificateAuthority = ""
+		cluster.CertificateAuthorityData = base64.StdEncoding.EncodeToString(ca)
+	}
+
+	user := kubeconfigUser{Token: cred.Token}
+	if cred.Token == "" {
+		user.ClientCertificateData = base64.StdEncoding.EncodeToString(cred.ClientCertificate)
+		user.ClientKeyData = base64.StdEncoding.EncodeToString(cred.ClientKey)
+	}
+
+	return &kubeconfig{
+		APIVersion: "v1",
+		Kind:       "Config",
+		Clusters: []kubeconfigNamedCluster{
+			{Name: context.Cluster, Cluster: *cluster},
+		},
+		Contexts: []kubeconfigNamedContext{
+			{
+				Name: newContextName,
+				Context: kubeconfigContext{
+					Cluster:   context.Cluster,
+					User:      cred.userName(),
+					Namespace: namespace,
+				},
+			},
+		},
+		CurrentContext: newContextName,
+		Preferences:    map[string]interface{}{},
+		Users: []kubeconfigNamedUser{
+			{Name: cred.userName(), User: user},
+		},
+	}, nil
+}
+
+// Serializes the kubeconfig to YAML
+func (k *kubeconfig) marshal() ([]byte, error) {
+	return yaml.Marshal(k)
+}
@@ -0,0 +1,94 @@
+package k8s
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const sourceKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: prod
+  cluster:
+    server: https://prod.example.com
+    certificate-authority: ca.crt
+    tls-server-name: api.prod.example.com
+- name: staging
+  cluster:
+    server: https://staging.example.com
+    certificate-authority-data: c3RhZ2luZw==
+contexts:
+- name: prod-admin
+  context:
+    cluster: prod
+    user: admin
+- name: staging-admin
+  context:
+    cluster: staging
+    user: admin
+current-context: staging-admin
+users:
+- name: admin
+  user:
+    token: admin-token
+`
+
+func TestExtractKubeconfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "ca.crt"), []byte("prod-ca"), 0600))
+
+	source, err := parseKubeconfig([]byte(sourceKubeconfig))
+	assert.Nil(t, err)
+
+	kc, err := source.extract("prod-admin", KubeconfigContextName, "spinnaker", credential{Token: "sa-token"}, dir)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "spinnaker", kc.CurrentContext)
+	assert.Len(t, kc.Clusters, 1)
+	assert.Equal(t, "prod", kc.Clusters[0].Name)
+	assert.Equal(t, "https://prod.example.com", kc.Clusters[0].Cluster.Server)
+	assert.Equal(t, "", kc.Clusters[0].Cluster.CertificateAuthority)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("prod-ca")), kc.Clusters[0].Cluster.CertificateAuthorityData)
+	assert.Equal(t, "api.prod.example.com", kc.Clusters[0].Cluster.Extra["tls-server-name"])
+	assert.Equal(t, kubeconfigContext{Cluster: "prod", User: "spinnaker-token-user", Namespace: "spinnaker"}, kc.Contexts[0].Context)
+	assert.Equal(t, []kubeconfigNamedUser{{Name: "spinnaker-token-user", User: kubeconfigUser{Token: "sa-token"}}}, kc.Users)
+
+	// Nothing from the source users may end up in the output
+	b, err := kc.marshal()
+	assert.Nil(t, err)
+	assert.NotContains(t, string(b), "admin-token")
+
+	roundTrip, err := parseKubeconfig(b)
+	assert.Nil(t, err)
+	assert.Equal(t, "spinnaker-token-user", roundTrip.Contexts[0].Context.User)
+}
+
+func TestExtractKubeconfigCertificate(t *testing.T) {
+	source, err := parseKubeconfig([]byte(sourceKubeconfig))
+	assert.Nil(t, err)
+
+	kc, err := source.extract("staging-admin", ReadOnlyKubeconfigContextName, "default", credential{ClientCertificate: []byte("cert"), ClientKey: []byte("key")}, "")
+	assert.Nil(t, err)
+
+	assert.Equal(t, "spinnaker-readonly", kc.CurrentContext)
+	assert.Equal(t, "c3RhZ2luZw==", kc.Clusters[0].Cluster.CertificateAuthorityData)
+	assert.Equal(t, "spinnaker-cert-user", kc.Users[0].Name)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("cert")), kc.Users[0].User.ClientCertificateData)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("key")), kc.Users[0].User.ClientKeyData)
+	assert.Equal(t, "", kc.Users[0].User.Token)
+}
+
+func TestExtractKubeconfigMissingContext(t *testing.T) {
+	source, err := parseKubeconfig([]byte(sourceKubeconfig))
+	assert.Nil(t, err)
+
+	_, err = source.extract("dev-admin", KubeconfigContextName, "default", credential{Token: "sa-token"}, "")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,139 @@
+package k8s
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ServiceAccountInfo : A service account created by this tool, as shown by list
+type ServiceAccountInfo struct {
+	Context   string    `json:"context" yaml:"context"`
+	Namespace string    `json:"namespace" yaml:"namespace"`
+	Name      string    `json:"name" yaml:"name"`
+	Created   time.Time `json:"created" yaml:"created"`
+	// The role preset, or for a built-in namespace binding, the built-in ClusterRole (edit, admin or view)
+	Role string `json:"role" yaml:"role"`
+	// cluster, or namespaced
+	Scope                   string   `json:"scope" yaml:"scope"`
+	NamespaceBinding        string   `json:"namespaceBinding,omitempty" yaml:"namespaceBinding,omitempty"`
+	TargetNamespaces        []string `json:"targetNamespaces,omitempty" yaml:"targetNamespaces,omitempty"`
+	TargetNamespaceSelector string   `json:"targetNamespaceSelector,omitempty" yaml:"targetNamespaceSelector,omitempty"`
+	// Credential in the last kubeconfig created for it: token, token-secret, certificate, or empty if
+	// unknown (see CredentialAnnotation)
+	Credential        string     `json:"credential,omitempty" yaml:"credential,omitempty"`
+	CredentialIssued  *time.Time `json:"credentialIssued,omitempty" yaml:"credentialIssued,omitempty"`
+	CredentialExpires *time.Time `json:"credentialExpires,omitempty" yaml:"credentialExpires,omitempty"`
+}
+
+// KubeconfigContexts : The contexts in a kubeconfig (defaulting to ~/.kube/config), and its current context
+// Returns kubeconfig file, context names, current context, error
+func KubeconfigContexts(kubeconfigFile string) (string, []string, string, error) {
+	if kubeconfigFile == "" {
+		kubeconfigFile = filepath.Join(os.Getenv("HOME"), ".kube/config")
+	} else if strings.HasPrefix(kubeconfigFile, "~/") {
+		kubeconfigFile = filepath.Join(os.Getenv("HOME"), kubeconfigFile[2:])
+	}
+	kc, err := loadKubeconfig(kubeconfigFile)
+	if err != nil {
+		return kubeconfigFile, nil, "", err
+	}
+	var names []string
+	for _, context := range kc.Contexts {
+		names = append(names, context.Name)
+	}
+	return kubeconfigFile, names, kc.CurrentContext, nil
+}
+
+// ListServiceAccounts : Finds the ServiceAccounts created (or adopted) by this tool (labelled with
+// ManagedByLabel), and works out from their annotations and RoleBindings what access they have
+// Nothing is printed, so the output of list can be piped
+// Returns service accounts, error string, error
+func (c *Cluster) ListServiceAccounts(verbose bool) ([]ServiceAccountInfo, string, error) {
+	var serviceAccounts struct {
+		Items []struct {
+			Metadata struct {
+				Name              string            `json:"name"`
+				Namespace         string            `json:"namespace"`
+				CreationTimestamp time.Time         `json:"creationTimestamp"`
+				Annotations       map[string]string `json:"annotations"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}
+	serr, err := c.getJSON([]string{
+		"get", "serviceaccounts", "--all-namespaces",
+		"-l", ManagedByLabel + "=" + ManagedByValue,
+	}, &serviceAccounts, verbose)
+	if err != nil {
+		return nil, "Unable to get service accounts:\n" + serr, err
+	}
+
+	var roleBindings roleBindingsJSON
+	serr, err = c.getJSON([]string{"get", "rolebindings", "--all-namespaces"}, &roleBindings, verbose)
+	if err != nil {
+		return nil, "Unable to get RoleBindings:\n" + serr, err
+	}
+
+	var infos []ServiceAccountInfo
+	for _, item := range serviceAccounts.Items {
+		sa := ServiceAccount{Namespace: item.Metadata.Namespace, ServiceAccountName: item.Metadata.Name}
+		var targets []string
+		for _, binding := range roleBindings.Items {
+			named := binding.Metadata.Name == sa.NamespaceRoleBindingName()
+			if (named || sa.ownsLabels(binding.Metadata.Labels)) && bindsServiceAccount(binding.Subjects, sa) && !contains(targets, binding.Metadata.Namespace) {
+				targets = append(targets, binding.Metadata.Namespace)
+			}
+		}
+		info := newServiceAccountInfo(sa, item.Metadata.Annotations, targets)
+		info.Context = c.Context.ContextName
+		info.Created = item.Metadata.CreationTimestamp
+		infos = append(infos, info)
+	}
+	return infos, "", nil
+}
+
+// Describes a service account from its annotations, and the namespaces it has a RoleBinding in
+// Called by ListServiceAccounts
+func newServiceAccountInfo(sa ServiceAccount, annotations map[string]string, targets []string) ServiceAccountInfo {
+	// A service account with annotations edited by hand is still listed, with what could be read
+	sa.setFromAnnotations(annotations)
+	sort.Strings(targets)
+
+	info := ServiceAccountInfo{
+		Namespace:               sa.Namespace,
+		Name:                    sa.ServiceAccountName,
+		Role:                    string(sa.Role),
+		Scope:                   "cluster",
+		TargetNamespaces:        targets,
+		TargetNamespaceSelector: sa.TargetNamespaceSelector,
+		Credential:              annotations[CredentialAnnotation],
+		CredentialIssued:        parseTimeAnnotation(annotations[CredentialIssuedAnnotation]),
+		CredentialExpires:       parseTimeAnnotation(annotations[CredentialExpiresAnnotation]),
+	}
+	if sa.NamespaceBinding != "" || sa.TargetNamespaceSelector != "" || len(targets) != 0 {
+		info.Scope = "namespaced"
+		info.NamespaceBinding = string(sa.NamespaceBinding)
+		if sa.NamespaceBinding.BuiltIn() {
+			info.Role = string(sa.NamespaceBinding)
+		}
+	}
+	// Service accounts created before role presets were recorded are cluster-admin; adopted ones whose
+	// bindings aren't a role preset have their own
+	if info.Role == "" && annotations[AdoptedBindingsAnnotation] != "" {
+		info.Role = CustomRole
+	} else if info.Role == "" {
+		info.Role = string(ClusterAdminRole)
+	}
+	return info
+}
+
+// Parses an RFC 3339 annotation; nil if it is missing or invalid
+func parseTimeAnnotation(s string) *time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
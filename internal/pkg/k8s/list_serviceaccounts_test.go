@@ -0,0 +1,37 @@
+package k8s
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewServiceAccountInfo(t *testing.T) {
+	sa := ServiceAccount{Namespace: "spinnaker", ServiceAccountName: "payments"}
+	info := newServiceAccountInfo(sa, map[string]string{
+		RoleAnnotation:                    "",
+		NamespaceBindingAnnotation:        "view",
+		TargetNamespaceSelectorAnnotation: "team=payments",
+		CredentialAnnotation:              "certificate",
+		CredentialIssuedAnnotation:        "2024-05-01T12:00:00Z",
+		CredentialExpiresAnnotation:       "not a time",
+	}, []string{"pay-2", "pay-1"})
+	assert.Equal(t, "view", info.Role)
+	assert.Equal(t, "namespaced", info.Scope)
+	assert.Equal(t, []string{"pay-1", "pay-2"}, info.TargetNamespaces)
+	assert.Equal(t, "team=payments", info.TargetNamespaceSelector)
+	assert.Equal(t, "certificate", info.Credential)
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), *info.CredentialIssued)
+	assert.Nil(t, info.CredentialExpires)
+
+	// Created before anything was recorded on it
+	info = newServiceAccountInfo(sa, nil, nil)
+	assert.Equal(t, string(ClusterAdminRole), info.Role)
+	assert.Equal(t, "cluster", info.Scope)
+	assert.Equal(t, "", info.Credential)
+
+	// Adopted, with bindings that aren't a role preset
+	info = newServiceAccountInfo(sa, map[string]string{AdoptedBindingsAnnotation: "ClusterRoleBinding ci"}, nil)
+	assert.Equal(t, CustomRole, info.Role)
+}
@@ -20,7 +20,6 @@ import (
 // * If ServiceAccountName is not specified, prompts for the service account name
 //
 // TODO: Be able to pass in values for these at start of execution
-// TODO: Prompt for non-admin service account perms
 func (c *Cluster) SelectServiceAccount(ctx diagnostics.Handler, sa *ServiceAccount, verbose bool) (string, error) {
 
 	color.Blue("Getting namespaces ...")
@@ -0,0 +1,116 @@
+package k8s
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/armory/spinnaker-tools/internal/pkg/diagnostics"
+	"github.com/armory/spinnaker-tools/internal/pkg/utils"
+	"github.com/fatih/color"
+)
+
+// SyncServiceAccount : Works out how to bring the target namespaces of a service account created with a
+// target namespace selector up to date, without changing anything, by doing the following:
+//   - Reads how the service account was set up from its annotations
+//   - Sets its target namespaces to the namespaces currently matching the selector
+//   - Compares it with the cluster (see DiffServiceAccount), so RoleBindings (and Roles) are created in
+//     matching namespaces it doesn't have them in yet, and deleted from namespaces that no longer match
+//
+// CreateServiceAccount then applies the diff
+// Returns diff, namespaces added, namespaces removed, error string, error
+func (c *Cluster) SyncServiceAccount(ctx diagnostics.Handler, sa *ServiceAccount, verbose bool) (ServiceAccountDiff, []string, []string, string, error) {
+	var diff ServiceAccountDiff
+	annotations, serr, err := c.getServiceAccountAnnotations(*sa, verbose)
+	if err != nil {
+		return diff, nil, nil, serr, err
+	}
+	if err := sa.setFromAnnotations(annotations); err != nil {
+		return diff, nil, nil, "Unable to read how service account " + sa.ServiceAccountName + " was set up", err
+	}
+	if sa.TargetNamespaceSelector == "" {
+		return diff, nil, nil, "Service account " + sa.ServiceAccountName + " was not created with --target-namespace-selector", errors.New("no target namespace selector")
+	}
+
+	color.Blue("Getting namespaces matching %s ...", sa.TargetNamespaceSelector)
+	desired, err := c.getNamespacesBySelector(ctx, sa.TargetNamespaceSelector, verbose)
+	if err != nil {
+		return diff, nil, nil, "Unable to get namespaces matching " + sa.TargetNamespaceSelector, err
+	}
+	sa.TargetNamespaces = desired
+
+	color.Blue("Comparing with the cluster ...")
+	diff, serr, err = c.DiffServiceAccount(sa, verbose)
+	if err != nil {
+		return diff, nil, nil, serr, err
+	}
+	added, removed := syncedNamespaces(diff)
+	return diff, added, removed, "", nil
+}
+
+// The namespaces a diff grants the service account access to (creating its RoleBinding), and removes
+// it from (deleting its RoleBinding); only RoleBindings found for it by OwnedRBAC are ever deleted, so
+// those with the same name binding anything else are left alone
+// Called by SyncServiceAccount
+func syncedNamespaces(diff ServiceAccountDiff) ([]string, []string) {
+	var added, removed []string
+	for _, change := range diff.Changes {
+		if change.Object.Kind != "RoleBinding" {
+			continue
+		}
+		switch change.Action {
+		case CreateObject:
+			added = append(added, change.Object.Namespace)
+		case DeleteObject:
+			removed = append(removed, change.Object.Namespace)
+		}
+	}
+	return added, removed
+}
+
+// Gets the annotations of the service account
+// Returns annotations, error string, error
+// Called by SyncServiceAccount
+func (c *Cluster) getServiceAccountAnnotations(sa ServiceAccount, verbose bool) (map[string]string, string, error) {
+	options := c.buildCommand([]string{
+		"get", "serviceaccount", sa.ServiceAccountName,
+		"-n", sa.Namespace,
+		"-o=json",
+	}, verbose)
+
+	o, bserr, err := utils.RunCommand(verbose, "kubectl", options...)
+	if err != nil {
+		return nil, "Unable to get service account " + sa.ServiceAccountName + ":\n" + bserr.String(), err
+	}
+
+	var s serviceAccountJSON
+	if err := json.NewDecoder(o).Decode(&s); err != nil {
+		return nil, "Cannot decode JSON for service account " + sa.ServiceAccountName, err
+	}
+	return s.Metadata.Annotations, "", nil
+}
+
+// Namespaces in desired but not in current (to add), and in current but not in desired (to remove)
+// Called by accessNotes
+func diffNamespaces(current []string, desired []string) ([]string, []string) {
+	currentSet := map[string]bool{}
+	for _, n := range current {
+		currentSet[n] = true
+	}
+	desiredSet := map[string]bool{}
+	for _, n := range desired {
+		desiredSet[n] = true
+	}
+
+	var added, removed []string
+	for _, n := range desired {
+		if !currentSet[n] {
+			added = append(added, n)
+		}
+	}
+	for _, n := range current {
+		if !desiredSet[n] {
+			removed = append(removed, n)
+		}
+	}
+	return added, removed
+}
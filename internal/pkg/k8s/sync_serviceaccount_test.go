@@ -0,0 +1,56 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestDiffNamespaces(t *testing.T) {
+	added, removed := diffNamespaces([]string{"a", "b"}, []string{"b", "c"})
+	assert.Equal(t, []string{"c"}, added)
+	assert.Equal(t, []string{"a"}, removed)
+
+	added, removed = diffNamespaces(nil, nil)
+	assert.Nil(t, added)
+	assert.Nil(t, removed)
+}
+
+func TestSyncedNamespaces(t *testing.T) {
+	added, removed := syncedNamespaces(ServiceAccountDiff{Changes: []ObjectChange{
+		{Object: ObjectRef{"ServiceAccount", "spinnaker", "payments"}, Action: UnchangedObject},
+		{Object: ObjectRef{"RoleBinding", "pay-1", "spinnaker-payments-binding"}, Action: UnchangedObject},
+		{Object: ObjectRef{"Role", "pay-2", "spinnaker-payments-local-admin"}, Action: CreateObject},
+		{Object: ObjectRef{"RoleBinding", "pay-2", "spinnaker-payments-binding"}, Action: CreateObject},
+		{Object: ObjectRef{"RoleBinding", "old", "spinnaker-payments-binding"}, Action: DeleteObject},
+		{Object: ObjectRef{"Role", "old", "spinnaker-payments-local-admin"}, Action: DeleteObject},
+		{Object: ObjectRef{"RoleBinding", "legacy", "ci"}, Action: KeepObject},
+	}})
+	assert.Equal(t, []string{"pay-2"}, added)
+	assert.Equal(t, []string{"old"}, removed)
+}
+
+func TestServiceAccountAnnotationsRoundTrip(t *testing.T) {
+	sa := ServiceAccount{
+		Namespace:               "spinnaker",
+		ServiceAccountName:      "payments",
+		Role:                    SpinnakerDeployerRole,
+		AllowSecrets:            true,
+		TargetNamespaceSelector: "team in (payments, billing)",
+		NamespaceBinding:        SharedClusterRole,
+	}
+	manifest, err := serviceAccountDefinition(sa, false)
+	assert.Nil(t, err)
+
+	var doc struct {
+		Metadata struct {
+			Annotations map[string]string `yaml:"annotations"`
+		} `yaml:"metadata"`
+	}
+	assert.Nil(t, yaml.Unmarshal([]byte(manifest), &doc))
+
+	synced := ServiceAccount{Namespace: "spinnaker", ServiceAccountName: "payments"}
+	assert.Nil(t, synced.setFromAnnotations(doc.Metadata.Annotations))
+	assert.Equal(t, sa, synced)
+}
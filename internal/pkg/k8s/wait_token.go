@@ -0,0 +1,89 @@
+package k8s
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/armory/spinnaker-tools/internal/pkg/diagnostics"
+	"github.com/armory/spinnaker-tools/internal/pkg/utils"
+	"github.com/fatih/color"
+)
+
+// WaitForToken : Waits until a token can be obtained for the service account, checking every
+// sa.Token.PollInterval for up to sa.Token.Timeout:
+// * If a token Secret is named in sa.Token, until the token controller has populated it
+// * On clusters older than 1.24, until the auto-created token Secret exists and is populated
+// * Otherwise, until the service account exists (tokens are requested on demand)
+// Returns error string, error
+func (c *Cluster) WaitForToken(ctx diagnostics.Handler, sa ServiceAccount, verbose bool) (string, error) {
+	serverMinor, serr, err := c.getServerMinorVersion(verbose)
+	if err != nil {
+		return serr, err
+	}
+
+	color.Blue("Waiting up to %s for a token for service account %s ...", sa.Token.Timeout, sa.ServiceAccountName)
+	start := time.Now()
+	for {
+		ready, status, err := c.tokenReady(sa, serverMinor, verbose)
+		if ready {
+			color.Green("Token for service account %s is available", sa.ServiceAccountName)
+			return "", nil
+		}
+
+		elapsed := time.Since(start)
+		if elapsed >= sa.Token.Timeout {
+			if err == nil {
+				err = errors.New("timed out waiting for token")
+			}
+			serr := fmt.Sprintf("No token for service account %s appeared within %s: %s", sa.ServiceAccountName, sa.Token.Timeout, status)
+			ctx.Error(serr, err)
+			return serr, err
+		}
+		fmt.Printf("%s (%s elapsed) ...\n", status, elapsed.Round(time.Second))
+		time.Sleep(sa.Token.PollInterval)
+	}
+}
+
+// Checks once whether a token can be obtained for the service account
+// Returns whether it can, a description of what is still missing, and the last error (if any)
+// Errors are expected while objects are still being created, so they are not fatal
+// Called by WaitForToken
+func (c *Cluster) tokenReady(sa ServiceAccount, serverMinor int, verbose bool) (bool, string, error) {
+	secretName := sa.Token.SecretName
+	if secretName == "" {
+		options := c.buildCommand([]string{
+			"get", "serviceaccount", sa.ServiceAccountName,
+			"-n", sa.Namespace,
+			"-o", "jsonpath={.secrets[0].name}",
+		}, verbose)
+
+		o, bserr, err := utils.RunCommand(verbose, "kubectl", options...)
+		if err != nil {
+			return false, "Service account " + sa.ServiceAccountName + " not found yet", errors.New(bserr.String())
+		}
+		if serverMinor >= noTokenSecretsMinor {
+			return true, "", nil
+		}
+		secretName = strings.TrimSpace(o.String())
+		if secretName == "" {
+			return false, "Token secret for service account " + sa.ServiceAccountName + " not created yet", nil
+		}
+	}
+
+	options := c.buildCommand([]string{
+		"get", "secret", secretName,
+		"-n", sa.Namespace,
+		"-o", "jsonpath={.data.token}",
+	}, verbose)
+
+	o, bserr, err := utils.RunCommand(verbose, "kubectl", options...)
+	if err != nil {
+		return false, "Token secret " + secretName + " not found yet", errors.New(bserr.String())
+	}
+	if o.Len() == 0 {
+		return false, "Token secret " + secretName + " not populated yet", nil
+	}
+	return true, "", nil
+}
@@ -0,0 +1,90 @@
+package spinnaker
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Account : A Clouddriver Kubernetes account (an entry of `kubernetes.accounts`)
+type Account struct {
+	Name                 string   `yaml:"name"`
+	KubeconfigFile       string   `yaml:"kubeconfigFile"`
+	Context              string   `yaml:"context"`
+	Namespaces           []string `yaml:"namespaces,omitempty"`
+	OnlySpinnakerManaged bool     `yaml:"onlySpinnakerManaged"`
+}
+
+// The `kubernetes` provider block of Spinnaker's configuration
+type kubernetesProvider struct {
+	Kubernetes struct {
+		Enabled  bool      `yaml:"enabled"`
+		Accounts []Account `yaml:"accounts"`
+	} `yaml:"kubernetes"`
+}
+
+// Account names must match Halyard's naming rules
+var accountNameRegexp = regexp.MustCompile(`^[a-z0-9]+([-a-z0-9_]*[a-z0-9])?$`)
+
+var invalidAccountNameChars = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// Arguments made only of these need no quoting in a POSIX shell
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// AccountName : Derives an account name from a Kubernetes cluster name
+// Only the last segment of ARN-style names is used (`arn:aws:eks:...:cluster/prod` becomes `prod`), and
+// characters Spinnaker doesn't allow are replaced with dashes (`gke_project_zone_prod` stays as is)
+func AccountName(clusterName string) string {
+	name := strings.ToLower(clusterName)
+	if i := strings.LastIndex(name, "/"); i != -1 {
+		name = name[i+1:]
+	}
+	name = invalidAccountNameChars.ReplaceAllString(name, "-")
+	return strings.Trim(name, "-_")
+}
+
+// ValidateAccountName : Checks an account name against Spinnaker's naming rules
+func ValidateAccountName(name string) error {
+	if !accountNameRegexp.MatchString(name) {
+		return errors.New("invalid Spinnaker account name `" + name + "`: must be lowercase letters, digits, `-` and `_`, starting and ending with a letter or digit")
+	}
+	return nil
+}
+
+// AccountsYAML : Renders the accounts as a `kubernetes` provider block for Spinnaker's configuration
+// (for example clouddriver-local.yml)
+func AccountsYAML(accounts ...Account) ([]byte, error) {
+	var p kubernetesProvider
+	p.Kubernetes.Enabled = true
+	p.Kubernetes.Accounts = accounts
+	return yaml.Marshal(p)
+}
+
+// HalCommand : The Halyard command that adds the account, with arguments quoted so it can be pasted into a shell
+func HalCommand(a Account) string {
+	command := []string{
+		"hal", "config", "provider", "kubernetes", "account", "add", a.Name,
+		"--kubeconfig-file", a.KubeconfigFile,
+		"--context", a.Context,
+	}
+	if len(a.Namespaces) != 0 {
+		command = append(command, "--namespaces", strings.Join(a.Namespaces, ","))
+	}
+	command = append(command, "--only-spinnaker-managed", strconv.FormatBool(a.OnlySpinnakerManaged))
+	for i, arg := range command {
+		command[i] = shellQuote(arg)
+	}
+	return strings.Join(command, " ")
+}
+
+// Quotes an argument for a POSIX shell, in single quotes (with any single quotes in it escaped) unless
+// it doesn't need them
+func shellQuote(arg string) string {
+	if shellSafe.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
@@ -0,0 +1,60 @@
+package spinnaker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountName(t *testing.T) {
+	assert.Equal(t, "prod", AccountName("arn:aws:eks:us-west-2:123456789012:cluster/prod"))
+	assert.Equal(t, "gke_project_us-central1-a_staging", AccountName("gke_project_us-central1-a_staging"))
+	assert.Equal(t, "kind-kind", AccountName("kind-kind"))
+	assert.Equal(t, "my-cluster-local", AccountName("My Cluster.local"))
+}
+
+func TestValidateAccountName(t *testing.T) {
+	assert.Nil(t, ValidateAccountName("prod-east"))
+	assert.Nil(t, ValidateAccountName("prod_east1"))
+	assert.NotNil(t, ValidateAccountName("Prod"))
+	assert.NotNil(t, ValidateAccountName("prod-"))
+	assert.NotNil(t, ValidateAccountName(""))
+}
+
+func TestAccountsYAML(t *testing.T) {
+	b, err := AccountsYAML(Account{
+		Name:                 "prod",
+		KubeconfigFile:       "/home/spinnaker/.kube/prod",
+		Context:              "spinnaker",
+		Namespaces:           []string{"apps", "jobs"},
+		OnlySpinnakerManaged: true,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, `kubernetes:
+  enabled: true
+  accounts:
+  - name: prod
+    kubeconfigFile: /home/spinnaker/.kube/prod
+    context: spinnaker
+    namespaces:
+    - apps
+    - jobs
+    onlySpinnakerManaged: true
+`, string(b))
+}
+
+func TestHalCommand(t *testing.T) {
+	assert.Equal(t,
+		"hal config provider kubernetes account add prod --kubeconfig-file /tmp/kc --context spinnaker --namespaces apps,jobs --only-spinnaker-managed false",
+		HalCommand(Account{Name: "prod", KubeconfigFile: "/tmp/kc", Context: "spinnaker", Namespaces: []string{"apps", "jobs"}}))
+	assert.Equal(t,
+		`hal config provider kubernetes account add prod --kubeconfig-file '/home/me/my kubeconfigs/prod;rm -rf ~' --context 'it'\''s $HOME' --only-spinnaker-managed true`,
+		HalCommand(Account{Name: "prod", KubeconfigFile: "/home/me/my kubeconfigs/prod;rm -rf ~", Context: "it's $HOME", OnlySpinnakerManaged: true}))
+}
+
+func TestShellQuote(t *testing.T) {
+	assert.Equal(t, "arn:aws:eks:us-west-2:1234:cluster/prod", shellQuote("arn:aws:eks:us-west-2:1234:cluster/prod"))
+	assert.Equal(t, "''", shellQuote(""))
+	assert.Equal(t, `'a b'`, shellQuote("a b"))
+	assert.Equal(t, `'it'\''s'`, shellQuote("it's"))
+}
@@ -0,0 +1,149 @@
+package spinnaker
+
+import (
+	"errors"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Header of new SpinnakerService patches; existing patches keep theirs
+const operatorAPIVersion = "spinnaker.armory.io/v1alpha2"
+const operatorServiceName = "spinnaker"
+
+// EncryptedFileReference : The reference Spinnaker uses to read a file from a Kubernetes Secret
+// (in the namespace Spinnaker runs in)
+func EncryptedFileReference(secretName string, key string) string {
+	return "encryptedFile:k8s!n:" + secretName + "!k:" + key
+}
+
+// OperatorKubeconfigFileKey : The key of the account's kubeconfig under spec.spinnakerConfig.files
+func OperatorKubeconfigFileKey(accountName string) string {
+	return accountName + "-kubeconfig"
+}
+
+// OperatorPatch : Adds the account to a kustomize-style patch for the Spinnaker Operator's SpinnakerService
+// * patch is the content of an existing patch to merge into (empty to start a new one); other
+//   accounts and settings in it are kept, and an account with the same name is replaced
+// * kubeconfig is embedded under spec.spinnakerConfig.files, with the account pointing to it; if it is
+//   nil, account.KubeconfigFile is used as is (for example an EncryptedFileReference)
+func OperatorPatch(patch []byte, account Account, kubeconfig []byte) ([]byte, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(patch, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc) == 0 {
+		doc = yaml.MapSlice{
+			{Key: "apiVersion", Value: operatorAPIVersion},
+			{Key: "kind", Value: "SpinnakerService"},
+			{Key: "metadata", Value: yaml.MapSlice{{Key: "name", Value: operatorServiceName}}},
+		}
+	}
+
+	if kubeconfig != nil {
+		account.KubeconfigFile = OperatorKubeconfigFileKey(account.Name)
+		files, err := getMap(doc, "spec", "spinnakerConfig", "files")
+		if err != nil {
+			return nil, err
+		}
+		files = setKey(files, account.KubeconfigFile, string(kubeconfig))
+		doc, err = setMap(doc, files, "spec", "spinnakerConfig", "files")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	kubernetes, err := getMap(doc, "spec", "spinnakerConfig", "config", "providers", "kubernetes")
+	if err != nil {
+		return nil, err
+	}
+	kubernetes = setKey(kubernetes, "enabled", true)
+
+	var accounts []interface{}
+	for _, kv := range kubernetes {
+		if kv.Key == "accounts" && kv.Value != nil {
+			var ok bool
+			if accounts, ok = kv.Value.([]interface{}); !ok {
+				return nil, errors.New("kubernetes accounts in patch is not a list")
+			}
+		}
+	}
+	accounts, err = setAccount(accounts, account)
+	if err != nil {
+		return nil, err
+	}
+	kubernetes = setKey(kubernetes, "accounts", accounts)
+
+	doc, err = setMap(doc, kubernetes, "spec", "spinnakerConfig", "config", "providers", "kubernetes")
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(doc)
+}
+
+// Replaces the account with the same name in the list, or adds it at the end
+func setAccount(accounts []interface{}, account Account) ([]interface{}, error) {
+	b, err := yaml.Marshal(account)
+	if err != nil {
+		return nil, err
+	}
+	var entry yaml.MapSlice
+	if err := yaml.Unmarshal(b, &entry); err != nil {
+		return nil, err
+	}
+
+	for i, a := range accounts {
+		if existing, ok := a.(yaml.MapSlice); ok {
+			for _, kv := range existing {
+				if kv.Key == "name" && kv.Value == account.Name {
+					accounts[i] = entry
+					return accounts, nil
+				}
+			}
+		}
+	}
+	return append(accounts, entry), nil
+}
+
+// Gets the map at path (empty if it doesn't exist yet)
+func getMap(m yaml.MapSlice, path ...string) (yaml.MapSlice, error) {
+	for _, key := range path {
+		var next yaml.MapSlice
+		for _, kv := range m {
+			if kv.Key == key && kv.Value != nil {
+				var ok bool
+				if next, ok = kv.Value.(yaml.MapSlice); !ok {
+					return nil, errors.New(key + " in patch is not a map")
+				}
+			}
+		}
+		m = next
+	}
+	return m, nil
+}
+
+// Sets the map at path, creating any missing parents
+func setMap(m yaml.MapSlice, value yaml.MapSlice, path ...string) (yaml.MapSlice, error) {
+	if len(path) == 1 {
+		return setKey(m, path[0], value), nil
+	}
+	child, err := getMap(m, path[0])
+	if err != nil {
+		return nil, err
+	}
+	child, err = setMap(child, value, path[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	return setKey(m, path[0], child), nil
+}
+
+// Sets key in the map, keeping its position if it already exists
+func setKey(m yaml.MapSlice, key string, value interface{}) yaml.MapSlice {
+	for i := range m {
+		if m[i].Key == key {
+			m[i].Value = value
+			return m
+		}
+	}
+	return append(m, yaml.MapItem{Key: key, Value: value})
+}
@@ -0,0 +1,85 @@
+package spinnaker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperatorPatchNew(t *testing.T) {
+	b, err := OperatorPatch(nil, Account{Name: "prod", Context: "spinnaker"}, []byte("apiVersion: v1\nkind: Config\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, `apiVersion: spinnaker.armory.io/v1alpha2
+kind: SpinnakerService
+metadata:
+  name: spinnaker
+spec:
+  spinnakerConfig:
+    files:
+      prod-kubeconfig: |
+        apiVersion: v1
+        kind: Config
+    config:
+      providers:
+        kubernetes:
+          enabled: true
+          accounts:
+          - name: prod
+            kubeconfigFile: prod-kubeconfig
+            context: spinnaker
+            onlySpinnakerManaged: false
+`, string(b))
+}
+
+func TestOperatorPatchMerge(t *testing.T) {
+	existing := `apiVersion: spinnaker.io/v1alpha2
+kind: SpinnakerService
+metadata:
+  name: spin
+spec:
+  spinnakerConfig:
+    config:
+      version: 2.27.0
+      providers:
+        kubernetes:
+          enabled: true
+          accounts:
+          - name: staging
+            kubeconfigFile: staging-kubeconfig
+          - name: prod
+            kubeconfigFile: old
+`
+	b, err := OperatorPatch([]byte(existing), Account{
+		Name:           "prod",
+		KubeconfigFile: EncryptedFileReference("spin-secrets", "prod"),
+		Context:        "spinnaker",
+		Namespaces:     []string{"apps"},
+	}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, `apiVersion: spinnaker.io/v1alpha2
+kind: SpinnakerService
+metadata:
+  name: spin
+spec:
+  spinnakerConfig:
+    config:
+      version: 2.27.0
+      providers:
+        kubernetes:
+          enabled: true
+          accounts:
+          - name: staging
+            kubeconfigFile: staging-kubeconfig
+          - name: prod
+            kubeconfigFile: encryptedFile:k8s!n:spin-secrets!k:prod
+            context: spinnaker
+            namespaces:
+            - apps
+            onlySpinnakerManaged: false
+`, string(b))
+}
+
+func TestOperatorPatchInvalid(t *testing.T) {
+	_, err := OperatorPatch([]byte("spec: [1, 2]\n"), Account{Name: "prod"}, nil)
+	assert.NotNil(t, err)
+}
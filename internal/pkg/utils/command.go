@@ -6,12 +6,14 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"strings"
 )
 
+// In verbose mode, the command is printed with credentials redacted
 func RunCommand(verbose bool, command string, args ...string) (*bytes.Buffer, *bytes.Buffer, error) {
 	if verbose {
 		fmt.Println(command)
-		fmt.Println(args)
+		fmt.Println(RedactArgs(args))
 	}
 	cmd := exec.Command(command, args...)
 	out := &bytes.Buffer{}
@@ -25,39 +27,13 @@ func RunCommand(verbose bool, command string, args ...string) (*bytes.Buffer, *b
 	return out, serr, nil
 }
 
-// TODO determine if this should return a *bytes.Buffer instead of a string
-func RunCommandToFile(verbose bool, command string, filename string, args ...string) (*bytes.Buffer, error) {
-	if verbose {
-		fmt.Println(command)
-		fmt.Println(args)
-		fmt.Println(filename) // Todo add additional stuff
-	}
-
-	cmd := exec.Command(command, args...)
-	outfile, err := os.Create(filename)
-	if err != nil {
-		return bytes.NewBufferString("Unable to create output file"), err
-	}
-	defer outfile.Close()
-
-	serr := &bytes.Buffer{}
-	cmd.Stdout = outfile
-	cmd.Stderr = serr
-
-	err = cmd.Run()
-	if err != nil {
-		return serr, err
-	}
-
-	cmd.Wait()
-	return nil, nil
-}
-
 // Need better passback here
+// In verbose mode, the command and stdin are printed with credentials redacted
 func RunCommandInput(verbose bool, command string, stdin string, args ...string) error {
 	if verbose {
 		fmt.Println(command)
-		fmt.Println(args)
+		fmt.Println(RedactArgs(args))
+		fmt.Println(RedactText(stdin))
 	}
 	c := exec.Command(command, args...)
 	s, err := c.StdinPipe()
@@ -76,3 +52,24 @@ func RunCommandInput(verbose bool, command string, stdin string, args ...string)
 	s.Close()
 	return c.Wait()
 }
+
+// RunCommandInputOutput : Like RunCommandInput, but returns stdout and stderr like RunCommand
+// In verbose mode, the command and stdin are printed with credentials redacted
+func RunCommandInputOutput(verbose bool, command string, stdin string, args ...string) (*bytes.Buffer, *bytes.Buffer, error) {
+	if verbose {
+		fmt.Println(command)
+		fmt.Println(RedactArgs(args))
+		fmt.Println(RedactText(stdin))
+	}
+	cmd := exec.Command(command, args...)
+	out := &bytes.Buffer{}
+	serr := &bytes.Buffer{}
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout = out
+	cmd.Stderr = serr
+	err := cmd.Run()
+	if err != nil {
+		return nil, serr, err
+	}
+	return out, serr, nil
+}
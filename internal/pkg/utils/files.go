@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+var interruptOnce sync.Once
+var interruptMutex sync.Mutex
+var interruptPaths = map[string]bool{}
+
+// RemoveOnInterrupt : Makes sure path is deleted if the process gets SIGINT or SIGTERM
+// Returns a function to call once the path no longer needs cleaning up
+func RemoveOnInterrupt(path string) func() {
+	interruptOnce.Do(func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			s := <-signals
+			interruptMutex.Lock()
+			for p := range interruptPaths {
+				os.RemoveAll(p)
+			}
+			if s == syscall.SIGTERM {
+				os.Exit(143)
+			}
+			os.Exit(130)
+		}()
+	})
+
+	interruptMutex.Lock()
+	interruptPaths[path] = true
+	interruptMutex.Unlock()
+
+	return func() {
+		interruptMutex.Lock()
+		delete(interruptPaths, path)
+		interruptMutex.Unlock()
+	}
+}
+
+// WriteFileAtomic : Writes data to filename with permissions perm
+// The data goes to a temporary file in the same directory first, which is renamed into place, so
+// filename never holds partial content; the temporary file is removed on any failure or interrupt
+func WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	done := RemoveOnInterrupt(f.Name())
+	defer done()
+
+	err = writeAndClose(f, data, perm)
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+func writeAndClose(f *os.File, data []byte, perm os.FileMode) error {
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
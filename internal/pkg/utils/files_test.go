@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "atomic")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "kubeconfig")
+	assert.Nil(t, ioutil.WriteFile(filename, []byte("old"), 0644))
+
+	assert.Nil(t, WriteFileAtomic(filename, []byte("new"), 0600))
+
+	b, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, "new", string(b))
+
+	info, err := os.Stat(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// No temporary files left behind
+	entries, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestWriteFileAtomicMissingDirectory(t *testing.T) {
+	err := WriteFileAtomic(filepath.Join(os.TempDir(), "does-not-exist", "kubeconfig"), []byte("new"), 0600)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// Flags whose values are credentials
+var sensitiveFlags = map[string]bool{
+	"--token":           true,
+	"--client-key":      true,
+	"--client-key-data": true,
+	"--password":        true,
+	"--from-literal":    true,
+}
+
+// Flags whose key=value values may hold credentials (such as custom template values); only the key is kept
+var keyValueFlags = map[string]bool{
+	"--set": true,
+}
+
+// Manifest / kubeconfig keys whose values are credentials
+var sensitiveKeys = regexp.MustCompile(`^(\s*(?:-\s+)?)(token|password|client-key-data|id-token|refresh-token|access-token)(\s*:\s*)\S.*$`)
+
+// Manifest keys whose nested values are all credentials (Secret data)
+var sensitiveBlocks = regexp.MustCompile(`^(\s*)(data|stringData)\s*:\s*$`)
+
+// JWTs (service account tokens), PEM blocks, and long base64 strings (keys, certificates, Secret data)
+var sensitiveValue = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*|-----BEGIN [A-Z ]+-----|[A-Za-z0-9+/]{64,}={0,2}`)
+
+// RedactArgs : Returns a copy of command arguments that is safe to log
+// Values of sensitive flags, the values (but not the keys) of --set key=value, and anything that looks
+// like a token or key, are masked
+func RedactArgs(args []string) []string {
+	r := make([]string, len(args))
+	maskNext, maskNextValue := false, false
+	for i, arg := range args {
+		switch {
+		case maskNext:
+			r[i] = redacted
+			maskNext = false
+		case maskNextValue:
+			r[i] = redactKeyValue(arg)
+			maskNextValue = false
+		case sensitiveFlags[arg]:
+			r[i] = arg
+			maskNext = true
+		case keyValueFlags[arg]:
+			r[i] = arg
+			maskNextValue = true
+		case strings.HasPrefix(arg, "--") && strings.Contains(arg, "=") && sensitiveFlags[arg[:strings.Index(arg, "=")]]:
+			r[i] = arg[:strings.Index(arg, "=")+1] + redacted
+		case strings.HasPrefix(arg, "--") && strings.Contains(arg, "=") && keyValueFlags[arg[:strings.Index(arg, "=")]]:
+			r[i] = arg[:strings.Index(arg, "=")+1] + redactKeyValue(arg[strings.Index(arg, "=")+1:])
+		default:
+			r[i] = sensitiveValue.ReplaceAllString(arg, redacted)
+		}
+	}
+	return r
+}
+
+// key=[REDACTED] for key=value; anything else is masked entirely
+func redactKeyValue(s string) string {
+	if i := strings.Index(s, "="); i > 0 {
+		return s[:i+1] + redacted
+	}
+	return redacted
+}
+
+// RedactText : Returns a copy of a YAML document (such as a manifest passed on stdin) that is safe to log
+// Values of sensitive keys, everything under Secret data, and anything that looks like a token or
+// key, are masked
+func RedactText(text string) string {
+	lines := strings.Split(text, "\n")
+	blockIndent := -1
+	for i, line := range lines {
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if blockIndent >= 0 {
+			if strings.TrimSpace(line) != "" && indent > blockIndent {
+				lines[i] = line[:indent] + redactYAMLValue(line[indent:])
+				continue
+			}
+			blockIndent = -1
+		}
+
+		if m := sensitiveBlocks.FindStringSubmatch(line); m != nil {
+			blockIndent = len(m[1])
+			continue
+		}
+		if m := sensitiveKeys.FindStringSubmatch(line); m != nil {
+			lines[i] = m[1] + m[2] + m[3] + redacted
+			continue
+		}
+		lines[i] = sensitiveValue.ReplaceAllString(line, redacted)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Masks the value in a `key: value` line
+func redactYAMLValue(line string) string {
+	i := strings.Index(line, ":")
+	if i == -1 {
+		return redacted
+	}
+	return line[:i+1] + " " + redacted
+}
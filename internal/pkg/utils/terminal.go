@@ -0,0 +1,13 @@
+package utils
+
+import (
+	"os"
+
+	"github.com/mattn/go-isatty"
+)
+
+// IsInteractive : Whether we can prompt the user (stdin is a terminal)
+// Used to fall back to defaults instead of prompting, when run from scripts and CI
+func IsInteractive() bool {
+	return isatty.IsTerminal(os.Stdin.Fd()) || isatty.IsCygwinTerminal(os.Stdin.Fd())
+}
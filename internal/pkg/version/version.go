@@ -0,0 +1,5 @@
+package version
+
+// Version : Version of spinnaker-tools, set when building a release with
+// -ldflags "-X github.com/armory/spinnaker-tools/internal/pkg/version.Version=<version>"
+var Version = "dev"